@@ -1,9 +1,12 @@
 package adapter
 
 import (
+	"context"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/persist"
@@ -387,3 +390,340 @@ func TestTransaction(t *testing.T) {
 		return
 	}
 }
+
+func TestGormAdapterOptions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "options.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewGormAdapter(db, WithTablePrefix("app1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewGormAdapter(db, WithTableName("rules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewGormAdapter(db, WithTableName("plain"), WithoutUniqueIndex()); err != nil {
+		t.Fatal(err)
+	}
+	if db.Table("plain").Migrator().HasIndex(&Rule{}, "idx_plain") {
+		t.Fatal("index idx_plain created")
+	}
+	for _, table := range []string{"app1_rbac_rule", "app1_rbac_version", "rules", "rules_version"} {
+		if !db.Migrator().HasTable(table) {
+			t.Fatalf("table %s not created", table)
+		}
+	}
+	for _, table := range []string{"rbac_rule", "rbac_version"} {
+		if db.Migrator().HasTable(table) {
+			t.Fatalf("table %s created", table)
+		}
+	}
+
+	// the stores are isolated
+	if err = a.AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.AddPolicy("p", "p", []string{"jack", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Table("app1_rbac_rule").Migrator().HasIndex(&Rule{}, "idx_app1_rbac_rule") {
+		t.Fatal("index idx_app1_rbac_rule not created")
+	}
+	if err = b.AddPolicy("p", "p", []string{"jack", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.AddPolicy("p", "p", []string{"jack", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := casbin.NewEnforcer("../examples/rbac_model.conf", a)
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"jack", "data1", "read"}})
+	if err = e.SavePolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Table("app1_rbac_rule").Migrator().HasIndex(&Rule{}, "idx_app1_rbac_rule") {
+		t.Fatal("index idx_app1_rbac_rule not created by SavePolicy()")
+	}
+	if err = a.RemovePolicy("p", "p", []string{"jack", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+	rules, _, err := b.ListRules(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("ListRules() returned %d rules, want 1", len(rules))
+	}
+
+	if err = a.AddVersion(&Version{Added: [][]string{{"p", "jack", "data1", "read"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := b.ListVersions(0, 0); len(versions) != 0 {
+		t.Fatalf("ListVersions() returned %d versions, want 0", len(versions))
+	}
+
+	// the tables aren't created when the migration is skipped
+	c, err := NewGormAdapter(db, WithTablePrefix("app2"), WithSkipMigration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable("app2_rbac_rule") {
+		t.Fatal("table app2_rbac_rule created")
+	}
+	if err = c.AddPolicy("p", "p", []string{"jack", "data1", "read"}); err == nil {
+		t.Fatal("AddPolicy() succeeded without table")
+	}
+}
+
+func TestEtcdAdapterConnectTimeout(t *testing.T) {
+	conn, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{"127.0.0.1:1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err = NewEtcdAdapter(conn, WithConnectTimeout(100*time.Millisecond)); err == nil {
+		t.Fatal("NewEtcdAdapter() connected to a closed port")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("NewEtcdAdapter() returned after %v", elapsed)
+	}
+}
+
+func TestEtcdAdapterOptions(t *testing.T) {
+	conn, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{"127.0.0.1:2379"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	opts := []Option{WithConnectTimeout(time.Second), WithRequestTimeout(5 * time.Second)}
+	a, err := NewEtcdAdapter(conn, append(opts, WithTablePrefix("/app1"))...)
+	if err != nil {
+		t.Skip(err)
+	}
+	b, err := NewEtcdAdapter(conn, append(opts, WithNamespace("/app2"))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Delete(context.TODO(), "/app1/", clientv3.WithPrefix())
+	defer conn.Delete(context.TODO(), "/app2/", clientv3.WithPrefix())
+
+	if err = a.AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.AddPolicy("p", "p", []string{"bob", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys of b are under its namespace
+	rsp, err := conn.Get(context.TODO(), "/app2/rbac/rbac_rule/p/bob", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Kvs) != 1 {
+		t.Fatalf("got %d keys in the namespace, want 1", len(rsp.Kvs))
+	}
+
+	for _, tt := range []struct {
+		a    *EtcdAdapter
+		want string
+	}{{a, "alice"}, {b, "bob"}} {
+		rules, _, err := tt.a.ListRules(Query{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 || rules[0].V0 != tt.want {
+			t.Fatalf("ListRules() = %v, want %s", rules, tt.want)
+		}
+	}
+}
+
+func TestGormAdapterCounts(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithoutUniqueIndex()}} {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "counts.db")), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := NewGormAdapter(db, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = a.AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+			t.Fatal(err)
+		}
+		if err = a.AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the stored and the repeated rules are skipped
+		n, err := a.AddPoliciesCount("p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data1", "read"}, {"bob", "data1", "read"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("AddPoliciesCount() = %d, want 1", n)
+		}
+		var count int64
+		if err = db.Model(&Rule{}).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("stored %d rules, want 2", count)
+		}
+
+		n, err = a.RemovePoliciesCount("p", "p", [][]string{{"alice", "data1", "read"}, {"jack", "data1", "read"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("RemovePoliciesCount() = %d, want 1", n)
+		}
+	}
+}
+
+func TestGormAdapterUniqueIndexMigration(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "unique.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// duplicate rules stored by a table without the index
+	if err = db.AutoMigrate(&Rule{}); err != nil {
+		t.Fatal(err)
+	}
+	rows := []Rule{
+		{PType: "p", V0: "alice", V1: "data1", V2: "read"},
+		{PType: "p", V0: "alice", V1: "data1", V2: "read"},
+		{PType: "p", V0: "bob", V1: "data1", V2: "read"},
+	}
+	if err = db.Create(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// the adapter starts without the index, and keeps the rules until the
+	// migration removes the duplicates
+	a, err := NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasIndex(&Rule{}, "idx_rbac_rule") {
+		t.Fatal("index idx_rbac_rule created over duplicate rules")
+	}
+	var count int64
+	if err = db.Model(&Rule{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("stored %d rules, want 3", count)
+	}
+
+	removed, err := a.MigrateUniqueIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("MigrateUniqueIndex() = %d, want 1", removed)
+	}
+	if err = db.Model(&Rule{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("stored %d rules, want 2", count)
+	}
+
+	// the database rejects a duplicate inserted behind the adapter's back
+	if err = db.Create(&Rule{PType: "p", V0: "bob", V1: "data1", V2: "read"}).Error; err == nil {
+		t.Fatal("Create() stored a duplicate rule")
+	}
+	n, err := a.AddPoliciesCount("p", "p", [][]string{{"bob", "data1", "read"}})
+	if err != nil || n != 0 {
+		t.Fatalf("AddPoliciesCount() = %d, %v, want 0", n, err)
+	}
+}
+
+func TestGormAdapterTableNameVersions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "versions.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewGormAdapter(db, WithTableName("app1_rule"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewGormAdapter(db, WithTableName("app2_rule"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.AddVersion(&Version{Added: [][]string{{"p", "alice", "data1", "read"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.AddVersion(&Version{Added: [][]string{{"p", "bob", "data1", "read"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		a    *GormAdapter
+		want string
+	}{{a, "alice"}, {b, "bob"}} {
+		versions, err := tt.a.ListVersions(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 1 || versions[0].ID != 1 || versions[0].Added[0][1] != tt.want {
+			t.Fatalf("ListVersions() = %v, want the version adding %s", versions, tt.want)
+		}
+	}
+}
+
+func TestEtcdAdapterTableNameVersions(t *testing.T) {
+	conn, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{"127.0.0.1:2379"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	opts := []Option{WithConnectTimeout(time.Second), WithTablePrefix("/versions")}
+	a, err := NewEtcdAdapter(conn, append(opts, WithTableName("app1_rule"))...)
+	if err != nil {
+		t.Skip(err)
+	}
+	b, err := NewEtcdAdapter(conn, append(opts, WithTableName("app2_rule"))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Delete(context.TODO(), "/versions/", clientv3.WithPrefix())
+
+	if err = a.AddVersion(&Version{Added: [][]string{{"p", "alice", "data1", "read"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.AddVersion(&Version{Added: [][]string{{"p", "bob", "data1", "read"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		a    *EtcdAdapter
+		want string
+	}{{a, "alice"}, {b, "bob"}} {
+		versions, err := tt.a.ListVersions(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 1 || versions[0].ID != 1 || versions[0].Added[0][1] != tt.want {
+			t.Fatalf("ListVersions() = %v, want the version adding %s", versions, tt.want)
+		}
+	}
+}
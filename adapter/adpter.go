@@ -24,6 +24,7 @@ package adapter
 
 import (
 	"errors"
+	"time"
 
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
@@ -58,6 +59,81 @@ type BatchFilter struct {
 	filters []Filter
 }
 
+// DefaultPageSize is the number of rules returned by ListRules when
+// Query.Limit is not set.
+var DefaultPageSize = 100
+
+// ErrInvalidPageToken is returned by ListRules when the page token was not
+// returned by the same adapter.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// Query selects a page of stored rules.
+type Query struct {
+	// PType matches any of the given ptypes.
+	PType []string
+	// V0Prefix matches the rules whose v0 starts with it.
+	V0Prefix string
+	V1       string
+	V2       string
+	// Limit is the maximum number of the returned rules.
+	Limit int
+	// Token is the page token returned by the previous ListRules call.
+	Token string
+}
+
+// Lister is implemented by the adapters which can page through the stored
+// rules, so that the filters don't need to be applied in memory.
+type Lister interface {
+	// ListRules returns the rules which match the query, ordered by their
+	// position in the storage, and the token of the next page. The token is
+	// empty when there are no more rules.
+	ListRules(query Query) ([]Rule, string, error)
+}
+
+// CountingAdapter is implemented by the adapters which report how many rules
+// a batch write actually changed in the storage, which is lower than the
+// number of given rules when some of them were already added or removed.
+type CountingAdapter interface {
+	// AddPoliciesCount adds the rules which aren't stored yet, and returns
+	// the number of added rules.
+	AddPoliciesCount(sec string, ptype string, rules [][]string) (int64, error)
+	// RemovePoliciesCount removes the rules, and returns the number of
+	// removed rules.
+	RemovePoliciesCount(sec string, ptype string, rules [][]string) (int64, error)
+}
+
+// TransactionalAdapter is implemented by the adapters which can apply several
+// writes atomically.
+type TransactionalAdapter interface {
+	// WithTransaction calls fn with an adapter whose writes are committed
+	// together when fn returns nil, and rolled back otherwise.
+	WithTransaction(fn func(tx persist.Adapter) error) error
+}
+
+// Version records the rules changed by a mutation. Each rule starts with
+// its ptype, such as ["p", "alice", "data1", "read"].
+type Version struct {
+	ID        int64      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Added     [][]string `gorm:"column:added;type:text;serializer:json" json:"added,omitempty"`
+	Removed   [][]string `gorm:"column:removed;type:text;serializer:json" json:"removed,omitempty"`
+	CreatedAt time.Time  `gorm:"column:created_at" json:"createdAt"`
+}
+
+func (Version) TableName() string {
+	return "rbac_version"
+}
+
+// VersionStore is implemented by the adapters which can keep the versions
+// of the rules next to the rules.
+type VersionStore interface {
+	// AddVersion stores the version and sets its ID, which is greater than
+	// the IDs of the stored versions.
+	AddVersion(v *Version) error
+	// ListVersions returns at most limit versions whose IDs are greater than
+	// after, in ascending order. Every version is returned when limit <= 0.
+	ListVersions(after int64, limit int) ([]*Version, error)
+}
+
 func (c *Rule) queryString() (interface{}, []interface{}) {
 	queryArgs := []interface{}{c.PType}
 
@@ -116,6 +192,21 @@ func (c *Rule) toStringPolicy() []string {
 	return policy
 }
 
+// match reports whether the fields of the rule starting at fieldIndex equal
+// fieldValues. An empty field value matches anything.
+func (c *Rule) match(fieldIndex int, fieldValues ...string) bool {
+	values := []string{c.V0, c.V1, c.V2, c.V3, c.V4, c.V5}
+	for i, value := range fieldValues {
+		if value == "" {
+			continue
+		}
+		if fieldIndex+i >= len(values) || values[fieldIndex+i] != value {
+			return false
+		}
+	}
+	return true
+}
+
 // checkQueryfield make sure the fields won't all be empty (string --> "")
 func checkQueryField(fieldValues []string) error {
 	for _, fieldValue := range fieldValues {
@@ -24,40 +24,87 @@ package adapter
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/casbin/casbin/v2/model"
 	"go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
 	"gorm.io/gorm"
 )
 
+// Prefix is the default prefix of the keys.
+//
+// Deprecated: use WithTablePrefix.
 var Prefix = "/rbac"
 
+const (
+	// DefaultConnectTimeout is the default timeout of the connection check of
+	// NewEtcdAdapter.
+	DefaultConnectTimeout = 5 * time.Second
+)
+
+// maxTxnOps is the default limit of operations in a single etcd transaction.
+const maxTxnOps = 128
+
 // EtcdAdapter represents the Gorm adapter for policy storage.
 type EtcdAdapter struct {
-	tablePrefix string
-	tableName   string
-	conn        *clientv3.Client
-	isFiltered  bool
+	tablePrefix    string
+	tableName      string
+	requestTimeout time.Duration
+	conn           clientv3.KV
+	isFiltered     bool
 }
 
-// NewEtcdAdapter is the constructor for Adapter.
-func NewEtcdAdapter(conn *clientv3.Client) (*EtcdAdapter, error) {
-	ctx := context.Background()
+var _ VersionStore = (*EtcdAdapter)(nil)
+
+// NewEtcdAdapter is the constructor for Adapter. The rules are stored under
+// the /rbac/rbac_rule prefix and the versions under /rbac/rbac_version,
+// unless the options change them.
+func NewEtcdAdapter(conn *clientv3.Client, opts ...Option) (*EtcdAdapter, error) {
+	options := newOptions(opts...)
+	if options.TablePrefix == "" {
+		options.TablePrefix = Prefix
+	}
+	if options.ConnectTimeout <= 0 {
+		options.ConnectTimeout = DefaultConnectTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.ConnectTimeout)
+	defer cancel()
 	_, err := conn.MemberList(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("connect etcd: %v", err)
 	}
 
-	a := &EtcdAdapter{tablePrefix: Prefix, conn: conn}
-	a.tableName = (&Rule{}).TableName()
+	a := &EtcdAdapter{
+		tablePrefix:    options.TablePrefix,
+		tableName:      options.TableName,
+		requestTimeout: options.RequestTimeout,
+		conn:           conn.KV,
+	}
+	if options.Namespace != "" {
+		a.conn = namespace.NewKV(conn.KV, options.Namespace)
+	}
 
 	return a, nil
 }
 
+// context returns the context of a request, which is cancelled after the
+// request timeout.
+func (a *EtcdAdapter) context() (context.Context, context.CancelFunc) {
+	if a.requestTimeout > 0 {
+		return context.WithTimeout(context.Background(), a.requestTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
 // getTableInstance return the dynamic table name
 func (a *EtcdAdapter) getTableInstance() string {
 	return path.Join(a.tablePrefix, a.tableName)
@@ -77,10 +124,10 @@ func (a *EtcdAdapter) ruleTable() func(db *gorm.DB) *gorm.DB {
 	}
 }
 
-func (a *EtcdAdapter) dropTable() error {
+func (a *EtcdAdapter) dropTable(ctx context.Context) error {
 
 	t := a.getFullTableName()
-	_, err := a.conn.Delete(context.TODO(), t, clientv3.WithPrefix())
+	_, err := a.conn.Delete(ctx, t, clientv3.WithPrefix())
 	if err != nil {
 		return err
 	}
@@ -91,7 +138,8 @@ func (a *EtcdAdapter) dropTable() error {
 // LoadPolicy loads policy from database.
 func (a *EtcdAdapter) LoadPolicy(model model.Model) error {
 
-	ctx := context.TODO()
+	ctx, cancel := a.context()
+	defer cancel()
 	key := a.getFullTableName()
 	options := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend)}
 
@@ -142,7 +190,8 @@ func (a *EtcdAdapter) LoadFilteredPolicy(model model.Model, filter interface{})
 		return errors.New("unsupported filter type")
 	}
 
-	ctx := context.TODO()
+	ctx, cancel := a.context()
+	defer cancel()
 	for _, f := range batchFilter.filters {
 		prefix := a.filterQuery(f)
 		rsp, err := a.conn.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
@@ -197,6 +246,146 @@ func (a *EtcdAdapter) filterQuery(filter Filter) string {
 	return prefix
 }
 
+// ListRules returns a page of the rules which match the query. The page token
+// is the key of the last returned rule.
+func (a *EtcdAdapter) ListRules(query Query) ([]Rule, string, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	ptypes := append([]string{}, query.PType...)
+	if len(ptypes) == 0 {
+		ptypes = []string{"p", "g", "g2"}
+	}
+	sort.Strings(ptypes)
+
+	if query.Token != "" && !strings.HasPrefix(query.Token, a.getTableInstance()+"/") {
+		return nil, "", ErrInvalidPageToken
+	}
+
+	ctx, cancel := a.context()
+	defer cancel()
+	rules := make([]Rule, 0, limit)
+	next := ""
+	for _, ptype := range ptypes {
+		prefix := path.Join(a.getTableInstance(), ptype) + "/" + query.V0Prefix
+		end := clientv3.GetPrefixRangeEnd(prefix)
+		from := prefix
+		if query.Token != "" && query.Token >= from {
+			from = query.Token + "\x00"
+		}
+
+		for from < end {
+			rsp, err := a.conn.Get(ctx, from, clientv3.WithRange(end), clientv3.WithLimit(int64(limit)),
+				clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+			if err != nil {
+				return nil, "", err
+			}
+
+			for _, kv := range rsp.Kvs {
+				rule := a.lineToRule(string(kv.Key))
+				if (query.V1 != "" && rule.V1 != query.V1) || (query.V2 != "" && rule.V2 != query.V2) {
+					continue
+				}
+				if len(rules) == limit {
+					return rules, next, nil
+				}
+				rules = append(rules, rule)
+				next = string(kv.Key)
+			}
+
+			if !rsp.More {
+				break
+			}
+			from = string(rsp.Kvs[len(rsp.Kvs)-1].Key) + "\x00"
+		}
+	}
+
+	return rules, "", nil
+}
+
+// getVersionPrefix returns the prefix of the keys of the versions, which
+// follows the table name so that the stores with different tables keep their
+// own versions.
+func (a *EtcdAdapter) getVersionPrefix() string {
+	return path.Join(a.tablePrefix, versionTableName(a.tableName))
+}
+
+// versionKey returns the key of a version, the IDs are zero padded so that
+// the keys are sorted by ID.
+func (a *EtcdAdapter) versionKey(id int64) string {
+	return path.Join(a.getVersionPrefix(), fmt.Sprintf("%020d", id))
+}
+
+// AddVersion stores the version under the next ID. It retries when another
+// client takes the same ID.
+func (a *EtcdAdapter) AddVersion(v *Version) error {
+	ctx, cancel := a.context()
+	defer cancel()
+	prefix := a.getVersionPrefix() + "/"
+	for {
+		rsp, err := a.conn.Get(ctx, prefix, clientv3.WithLastKey()...)
+		if err != nil {
+			return err
+		}
+		id := int64(1)
+		if len(rsp.Kvs) > 0 {
+			last, err := strconv.ParseInt(strings.TrimPrefix(string(rsp.Kvs[0].Key), prefix), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid version key %s", rsp.Kvs[0].Key)
+			}
+			id = last + 1
+		}
+
+		v.ID = id
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		key := a.versionKey(id)
+		txn, err := a.conn.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, string(data))).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+	}
+}
+
+// ListVersions returns the versions whose IDs are greater than after.
+func (a *EtcdAdapter) ListVersions(after int64, limit int) ([]*Version, error) {
+	prefix := a.getVersionPrefix() + "/"
+	options := []clientv3.OpOption{
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix)),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+	}
+	if limit > 0 {
+		options = append(options, clientv3.WithLimit(int64(limit)))
+	}
+
+	ctx, cancel := a.context()
+	defer cancel()
+	rsp, err := a.conn.Get(ctx, a.versionKey(after+1), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*Version, 0, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		v := &Version{}
+		if err = json.Unmarshal(kv.Value, v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
 func (a *EtcdAdapter) savePolicyLine(ptype string, rule []string) string {
 	line := a.getTableInstance()
 
@@ -225,6 +414,7 @@ func (a *EtcdAdapter) savePolicyLine(ptype string, rule []string) string {
 
 func (a *EtcdAdapter) lineToRule(line string) Rule {
 	line = strings.TrimPrefix(line, a.getFullTableName())
+	line = strings.TrimPrefix(line, "/")
 
 	rule := Rule{}
 	parts := strings.Split(line, "/")
@@ -241,13 +431,13 @@ func (a *EtcdAdapter) lineToRule(line string) Rule {
 		rule.V2 = parts[3]
 	}
 	if len(parts) > 4 {
-		rule.V3 = parts[3]
+		rule.V3 = parts[4]
 	}
 	if len(parts) > 5 {
-		rule.V4 = parts[4]
+		rule.V4 = parts[5]
 	}
 	if len(parts) > 6 {
-		rule.V5 = parts[5]
+		rule.V5 = parts[6]
 	}
 
 	return rule
@@ -283,12 +473,13 @@ func (a *EtcdAdapter) ruleToLine(rule Rule) string {
 
 // SavePolicy saves policy to database.
 func (a *EtcdAdapter) SavePolicy(model model.Model) error {
-	if err := a.dropTable(); err != nil {
+	ctx, cancel := a.context()
+	defer cancel()
+
+	if err := a.dropTable(ctx); err != nil {
 		return err
 	}
 
-	ctx := context.TODO()
-
 	var lines []string
 	flushEvery := 1000
 	for ptype, ast := range model["p"] {
@@ -332,15 +523,21 @@ func (a *EtcdAdapter) SavePolicy(model model.Model) error {
 
 // AddPolicy adds a policy rule to the storage.
 func (a *EtcdAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	ctx, cancel := a.context()
+	defer cancel()
+
 	line := a.savePolicyLine(ptype, rule)
-	_, err := a.conn.Put(context.TODO(), line, "")
+	_, err := a.conn.Put(ctx, line, "")
 	return err
 }
 
 // RemovePolicy removes a policy rule from the storage.
 func (a *EtcdAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	ctx, cancel := a.context()
+	defer cancel()
+
 	line := a.savePolicyLine(ptype, rule)
-	err := a.rawDelete(a.lineToRule(line)) //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
+	err := a.rawDelete(ctx, a.lineToRule(line)) //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
 	return err
 }
 
@@ -351,7 +548,8 @@ func (a *EtcdAdapter) AddPolicies(sec string, ptype string, rules [][]string) er
 		line := a.savePolicyLine(ptype, rule)
 		lines = append(lines, line)
 	}
-	ctx := context.TODO()
+	ctx, cancel := a.context()
+	defer cancel()
 	for _, line := range lines {
 		_, err := a.conn.Put(ctx, line, "")
 		if err != nil {
@@ -363,9 +561,12 @@ func (a *EtcdAdapter) AddPolicies(sec string, ptype string, rules [][]string) er
 
 // RemovePolicies removes multiple policy rules from the storage.
 func (a *EtcdAdapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
+	ctx, cancel := a.context()
+	defer cancel()
+
 	for _, rule := range rules {
 		line := a.savePolicyLine(ptype, rule)
-		if err := a.rawDelete(a.lineToRule(line)); err != nil { //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
+		if err := a.rawDelete(ctx, a.lineToRule(line)); err != nil { //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
 			return err
 		}
 	}
@@ -374,50 +575,73 @@ func (a *EtcdAdapter) RemovePolicies(sec string, ptype string, rules [][]string)
 
 // RemoveFilteredPolicy removes policy rules that match the filter from the storage.
 func (a *EtcdAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
-	line := a.lineToRule(a.getTableInstance())
-
-	line.PType = ptype
-
-	if fieldIndex == -1 {
-		return a.rawDelete(line)
+	if fieldIndex != -1 {
+		if err := checkQueryField(fieldValues); err != nil {
+			return err
+		}
 	}
 
-	err := checkQueryField(fieldValues)
+	ctx, cancel := a.context()
+	defer cancel()
+	rules, err := a.filteredRules(ctx, ptype, fieldIndex, fieldValues...)
 	if err != nil {
 		return err
 	}
 
-	if fieldIndex <= 0 && 0 < fieldIndex+len(fieldValues) {
-		line.V0 = fieldValues[0-fieldIndex]
-	}
-	if fieldIndex <= 1 && 1 < fieldIndex+len(fieldValues) {
-		line.V1 = fieldValues[1-fieldIndex]
-	}
-	if fieldIndex <= 2 && 2 < fieldIndex+len(fieldValues) {
-		line.V2 = fieldValues[2-fieldIndex]
+	ops := make([]clientv3.Op, 0, len(rules))
+	for _, rule := range rules {
+		ops = append(ops, clientv3.OpDelete(a.ruleToLine(rule)))
 	}
-	if fieldIndex <= 3 && 3 < fieldIndex+len(fieldValues) {
-		line.V3 = fieldValues[3-fieldIndex]
+	return a.commit(ctx, ops)
+}
+
+// filteredRules returns the rules of ptype whose fields starting at fieldIndex
+// match fieldValues. An empty field value matches anything.
+func (a *EtcdAdapter) filteredRules(ctx context.Context, ptype string, fieldIndex int, fieldValues ...string) ([]Rule, error) {
+	key := path.Join(a.getTableInstance(), ptype) + "/"
+	rsp, err := a.conn.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
 	}
-	if fieldIndex <= 4 && 4 < fieldIndex+len(fieldValues) {
-		line.V4 = fieldValues[4-fieldIndex]
+
+	rules := make([]Rule, 0)
+	for _, kv := range rsp.Kvs {
+		rule := a.lineToRule(string(kv.Key))
+		if fieldIndex == -1 || rule.match(fieldIndex, fieldValues...) {
+			rules = append(rules, rule)
+		}
 	}
-	if fieldIndex <= 5 && 5 < fieldIndex+len(fieldValues) {
-		line.V5 = fieldValues[5-fieldIndex]
+
+	return rules, nil
+}
+
+// commit applies the operations in transactions of at most maxTxnOps operations.
+func (a *EtcdAdapter) commit(ctx context.Context, ops []clientv3.Op) error {
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		if _, err := a.conn.Txn(ctx).Then(ops[:n]...).Commit(); err != nil {
+			return err
+		}
+		ops = ops[n:]
 	}
-	err = a.rawDelete(line)
-	return err
+	return nil
 }
 
-func (a *EtcdAdapter) rawDelete(line Rule) error {
-	_, err := a.conn.Delete(context.TODO(), a.ruleToLine(line))
+func (a *EtcdAdapter) rawDelete(ctx context.Context, line Rule) error {
+	_, err := a.conn.Delete(ctx, a.ruleToLine(line))
 	return err
 }
 
 // UpdatePolicy updates a new policy rule to DB.
 func (a *EtcdAdapter) UpdatePolicy(sec string, ptype string, oldRule, newPolicy []string) error {
+	ctx, cancel := a.context()
+	defer cancel()
+
 	newLine := a.savePolicyLine(ptype, newPolicy)
-	_, err := a.conn.Put(context.TODO(), newLine, "")
+	_, err := a.conn.Put(ctx, newLine, "")
 	return err
 }
 
@@ -426,7 +650,8 @@ func (a *EtcdAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRule
 	for _, newRule := range newRules {
 		newPolicies = append(newPolicies, a.savePolicyLine(ptype, newRule))
 	}
-	ctx := context.TODO()
+	ctx, cancel := a.context()
+	defer cancel()
 	for i := range newPolicies {
 		if _, err := a.conn.Delete(ctx, newPolicies[i]); err != nil {
 			return err
@@ -438,51 +663,23 @@ func (a *EtcdAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRule
 
 func (a *EtcdAdapter) UpdateFilteredPolicies(sec string, ptype string, newPolicies [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
 	// UpdateFilteredPolicies deletes old rules and adds new rules.
-	line := Rule{}
-
-	line.PType = ptype
-	if fieldIndex <= 0 && 0 < fieldIndex+len(fieldValues) {
-		line.V0 = fieldValues[0-fieldIndex]
-	}
-	if fieldIndex <= 1 && 1 < fieldIndex+len(fieldValues) {
-		line.V1 = fieldValues[1-fieldIndex]
-	}
-	if fieldIndex <= 2 && 2 < fieldIndex+len(fieldValues) {
-		line.V2 = fieldValues[2-fieldIndex]
-	}
-	if fieldIndex <= 3 && 3 < fieldIndex+len(fieldValues) {
-		line.V3 = fieldValues[3-fieldIndex]
-	}
-	if fieldIndex <= 4 && 4 < fieldIndex+len(fieldValues) {
-		line.V4 = fieldValues[4-fieldIndex]
-	}
-	if fieldIndex <= 5 && 5 < fieldIndex+len(fieldValues) {
-		line.V5 = fieldValues[5-fieldIndex]
+	ctx, cancel := a.context()
+	defer cancel()
+	oldP, err := a.filteredRules(ctx, ptype, fieldIndex, fieldValues...)
+	if err != nil {
+		return nil, err
 	}
 
-	newP := make([]string, 0, len(newPolicies))
-	oldP := make([]Rule, 0)
+	ops := make([]clientv3.Op, 0, len(oldP)+len(newPolicies))
+	for _, rule := range oldP {
+		ops = append(ops, clientv3.OpDelete(a.ruleToLine(rule)))
+	}
 	for _, newRule := range newPolicies {
-		newP = append(newP, a.savePolicyLine(ptype, newRule))
+		ops = append(ops, clientv3.OpPut(a.savePolicyLine(ptype, newRule), ""))
 	}
-
-	ctx := context.TODO()
-	key := a.ruleToLine(line)
-	rsp, err := a.conn.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
-	if err != nil {
+	if err = a.commit(ctx, ops); err != nil {
 		return nil, err
 	}
-	for _, kv := range rsp.Kvs {
-		rule := a.lineToRule(string(kv.Key))
-		oldP = append(oldP, rule)
-		_ = a.rawDelete(rule)
-	}
-
-	for i := range newP {
-		if _, err := a.conn.Put(ctx, newP[i], ""); err != nil {
-			return nil, err
-		}
-	}
 
 	// return deleted rulues
 	oldPolicies := make([][]string, 0)
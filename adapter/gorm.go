@@ -26,23 +26,40 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	log "github.com/vine-io/vine/lib/logger"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// batchSize is the number of rules written or read by a single statement.
+const batchSize = 500
+
 // GormAdapter represents the Gorm adapter for policy storage.
 type GormAdapter struct {
-	tablePrefix string
-	tableName   string
-	db          *gorm.DB
-	isFiltered  bool
+	tablePrefix   string
+	tableName     string
+	skipMigration bool
+	uniqueIndex   bool
+	db            *gorm.DB
+	isFiltered    bool
 }
 
-// NewGormAdapter is the constructor for Adapter.
-func NewGormAdapter(db *gorm.DB) (*GormAdapter, error) {
+var (
+	_ CountingAdapter      = (*GormAdapter)(nil)
+	_ TransactionalAdapter = (*GormAdapter)(nil)
+	_ VersionStore         = (*GormAdapter)(nil)
+)
+
+// NewGormAdapter is the constructor for Adapter. The rules are stored in the
+// rbac_rule table and the versions in the rbac_version table, unless the
+// options change them.
+func NewGormAdapter(db *gorm.DB, opts ...Option) (*GormAdapter, error) {
 	conn, err := db.DB()
 	if err != nil {
 		return nil, err
@@ -52,10 +69,22 @@ func NewGormAdapter(db *gorm.DB) (*GormAdapter, error) {
 		return nil, err
 	}
 
-	a := &GormAdapter{db: db}
-	a.tableName = (&Rule{}).TableName()
-	err = db.AutoMigrate(&Rule{})
-	if err != nil {
+	options := newOptions(opts...)
+	a := &GormAdapter{
+		tablePrefix:   options.TablePrefix,
+		tableName:     options.TableName,
+		skipMigration: options.SkipMigration,
+		uniqueIndex:   options.UniqueIndex,
+		db:            db,
+	}
+	if a.skipMigration {
+		return a, nil
+	}
+
+	if err = a.createTable(); err != nil {
+		return nil, err
+	}
+	if err = a.db.Table(a.getVersionTableName()).AutoMigrate(&Version{}); err != nil {
 		return nil, err
 	}
 
@@ -74,6 +103,13 @@ func (a *GormAdapter) getFullTableName() string {
 	return a.tableName
 }
 
+func (a *GormAdapter) getVersionTableName() string {
+	if a.tablePrefix != "" {
+		return a.tablePrefix + "_" + versionTableName(a.tableName)
+	}
+	return versionTableName(a.tableName)
+}
+
 func (a *GormAdapter) ruleTable() func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
 		tableName := a.getFullTableName()
@@ -84,35 +120,97 @@ func (a *GormAdapter) ruleTable() func(db *gorm.DB) *gorm.DB {
 func (a *GormAdapter) createTable() error {
 
 	t := a.getTableInstance()
-	if err := a.db.AutoMigrate(t); err != nil {
+	if err := a.db.Scopes(a.ruleTable()).AutoMigrate(t); err != nil {
 		return err
 	}
+	if !a.uniqueIndex {
+		return nil
+	}
 
-	tableName := a.getFullTableName()
-	index := strings.ReplaceAll("idx_"+tableName, ".", "_")
-	hasIndex := a.db.Migrator().HasIndex(t, index)
-	if !hasIndex {
-		if err := a.db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (ptype,v0,v1,v2,v3,v4,v5)", index, tableName)).Error; err != nil {
-			return err
+	index := a.uniqueIndexName()
+	if a.db.Scopes(a.ruleTable()).Migrator().HasIndex(t, index) {
+		return nil
+	}
+
+	// the duplicate rules stored without the index are left to
+	// MigrateUniqueIndex, which removes them
+	duplicates, err := a.countDuplicates(a.db)
+	if err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		log.Warnf("adapter: %s holds %d duplicate rules, the unique index is created by MigrateUniqueIndex", a.getFullTableName(), duplicates)
+		return nil
+	}
+	return a.createUniqueIndex(a.db)
+}
+
+// uniqueIndexName returns the name of the unique index on the fields of the
+// rules.
+func (a *GormAdapter) uniqueIndexName() string {
+	return strings.ReplaceAll("idx_"+a.getFullTableName(), ".", "_")
+}
+
+func (a *GormAdapter) createUniqueIndex(db *gorm.DB) error {
+	return db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (ptype,v0,v1,v2,v3,v4,v5)", a.uniqueIndexName(), a.getFullTableName())).Error
+}
+
+// countDuplicates returns the number of rules stored more than once.
+func (a *GormAdapter) countDuplicates(db *gorm.DB) (int64, error) {
+	var n int64
+	query := fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s GROUP BY ptype,v0,v1,v2,v3,v4,v5 HAVING COUNT(*) > 1) AS duplicates", a.getFullTableName())
+	err := db.Raw(query).Scan(&n).Error
+	return n, err
+}
+
+// MigrateUniqueIndex removes the duplicate rules stored without the unique
+// index, keeping the first one of each, and creates the index. It deletes
+// rows, so it is run once by the operator rather than by every replica, and
+// it returns and logs the number of removed rows.
+func (a *GormAdapter) MigrateUniqueIndex() (int64, error) {
+	var removed int64
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		tableName := a.getFullTableName()
+		dedup := fmt.Sprintf("DELETE FROM %[1]s WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM %[1]s GROUP BY ptype,v0,v1,v2,v3,v4,v5) AS keep)", tableName)
+		result := tx.Exec(dedup)
+		if result.Error != nil {
+			return result.Error
+		}
+		removed = result.RowsAffected
+
+		if tx.Scopes(a.ruleTable()).Migrator().HasIndex(a.getTableInstance(), a.uniqueIndexName()) {
+			return nil
 		}
+		return a.createUniqueIndex(tx)
+	})
+	if err != nil {
+		return 0, err
 	}
-	return nil
+
+	log.Infof("adapter: removed %d duplicate rules from %s", removed, a.getFullTableName())
+	return removed, nil
 }
 
 func (a *GormAdapter) dropTable() error {
 
-	t := a.getTableInstance()
-	if err := a.db.Migrator().DropTable(t); err != nil {
+	if err := a.db.Migrator().DropTable(a.getFullTableName()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// withDB returns a copy of the adapter which uses db, such as a transaction.
+func (a *GormAdapter) withDB(db *gorm.DB) *GormAdapter {
+	b := *a
+	b.db = db
+	return &b
+}
+
 // LoadPolicy loads policy from database.
 func (a *GormAdapter) LoadPolicy(model model.Model) error {
 	var lines []Rule
-	if err := a.db.Order("ID").Find(&lines).Error; err != nil {
+	if err := a.db.Scopes(a.ruleTable()).Order("ID").Find(&lines).Error; err != nil {
 		return err
 	}
 	err := a.Preview(&lines, model)
@@ -152,7 +250,7 @@ func (a *GormAdapter) LoadFilteredPolicy(model model.Model, filter interface{})
 	}
 
 	for _, f := range batchFilter.filters {
-		if err := a.db.Scopes(a.filterQuery(a.db, f)).Order("ID").Find(&lines).Error; err != nil {
+		if err := a.db.Scopes(a.ruleTable(), a.filterQuery(a.db, f)).Order("ID").Find(&lines).Error; err != nil {
 			return err
 		}
 
@@ -201,6 +299,72 @@ func (a *GormAdapter) filterQuery(db *gorm.DB, filter Filter) func(db *gorm.DB)
 	}
 }
 
+// ListRules returns a page of the rules which match the query.
+func (a *GormAdapter) ListRules(query Query) ([]Rule, string, error) {
+	db := a.db.Scopes(a.ruleTable())
+	if len(query.PType) > 0 {
+		db = db.Where("ptype in (?)", query.PType)
+	}
+	if query.V0Prefix != "" {
+		db = db.Where("v0 LIKE ? ESCAPE '!'", escapeLike(query.V0Prefix)+"%")
+	}
+	if query.V1 != "" {
+		db = db.Where("v1 = ?", query.V1)
+	}
+	if query.V2 != "" {
+		db = db.Where("v2 = ?", query.V2)
+	}
+	if query.Token != "" {
+		id, err := strconv.ParseUint(query.Token, 10, 64)
+		if err != nil {
+			return nil, "", ErrInvalidPageToken
+		}
+		db = db.Where("id > ?", id)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	var lines []Rule
+	if err := db.Order("id").Limit(limit + 1).Find(&lines).Error; err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(lines) > limit {
+		lines = lines[:limit]
+		next = strconv.FormatUint(uint64(lines[limit-1].ID), 10)
+	}
+
+	return lines, next, nil
+}
+
+// AddVersion stores the version in the rbac_version table.
+func (a *GormAdapter) AddVersion(v *Version) error {
+	return a.db.Table(a.getVersionTableName()).Create(v).Error
+}
+
+// ListVersions returns the versions whose IDs are greater than after.
+func (a *GormAdapter) ListVersions(after int64, limit int) ([]*Version, error) {
+	db := a.db.Table(a.getVersionTableName()).Where("id > ?", after).Order("id")
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+
+	versions := make([]*Version, 0)
+	if err := db.Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with '!'.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
 func (a *GormAdapter) savePolicyLine(ptype string, rule []string) Rule {
 	line := a.getTableInstance()
 
@@ -229,13 +393,18 @@ func (a *GormAdapter) savePolicyLine(ptype string, rule []string) Rule {
 
 // SavePolicy saves policy to database.
 func (a *GormAdapter) SavePolicy(model model.Model) error {
-	if err := a.dropTable(); err != nil {
-		return err
-	}
-
-	err := a.createTable()
-	if err != nil {
-		return err
+	if a.skipMigration {
+		// the table is managed by the caller, so it is emptied instead
+		if err := a.db.Scopes(a.ruleTable()).Where("1 = 1").Delete(&Rule{}).Error; err != nil {
+			return err
+		}
+	} else {
+		if err := a.dropTable(); err != nil {
+			return err
+		}
+		if err := a.createTable(); err != nil {
+			return err
+		}
 	}
 
 	var lines []Rule
@@ -244,7 +413,7 @@ func (a *GormAdapter) SavePolicy(model model.Model) error {
 		for _, rule := range ast.Policy {
 			lines = append(lines, a.savePolicyLine(ptype, rule))
 			if len(lines) > flushEvery {
-				if err := a.db.Create(&lines).Error; err != nil {
+				if err := a.db.Scopes(a.ruleTable()).Create(&lines).Error; err != nil {
 					return err
 				}
 				lines = nil
@@ -256,7 +425,7 @@ func (a *GormAdapter) SavePolicy(model model.Model) error {
 		for _, rule := range ast.Policy {
 			lines = append(lines, a.savePolicyLine(ptype, rule))
 			if len(lines) > flushEvery {
-				if err := a.db.Create(&lines).Error; err != nil {
+				if err := a.db.Scopes(a.ruleTable()).Create(&lines).Error; err != nil {
 					return err
 				}
 				lines = nil
@@ -264,7 +433,7 @@ func (a *GormAdapter) SavePolicy(model model.Model) error {
 		}
 	}
 	if len(lines) > 0 {
-		if err := a.db.Create(&lines).Error; err != nil {
+		if err := a.db.Scopes(a.ruleTable()).Create(&lines).Error; err != nil {
 			return err
 		}
 	}
@@ -272,28 +441,115 @@ func (a *GormAdapter) SavePolicy(model model.Model) error {
 	return nil
 }
 
-// AddPolicy adds a policy rule to the storage.
+// AddPolicy adds a policy rule to the storage, it does nothing when the rule
+// is already stored.
 func (a *GormAdapter) AddPolicy(sec string, ptype string, rule []string) error {
-	line := a.savePolicyLine(ptype, rule)
-	err := a.db.Create(&line).Error
+	_, err := a.AddPoliciesCount(sec, ptype, [][]string{rule})
 	return err
 }
 
 // RemovePolicy removes a policy rule from the storage.
 func (a *GormAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
 	line := a.savePolicyLine(ptype, rule)
-	err := a.rawDelete(a.db, line) //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
+	_, err := a.rawDelete(a.db, line) //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
 	return err
 }
 
-// AddPolicies adds multiple policy rules to the storage.
+// AddPolicies adds multiple policy rules to the storage, skipping the rules
+// which are already stored.
 func (a *GormAdapter) AddPolicies(sec string, ptype string, rules [][]string) error {
-	var lines []Rule
+	_, err := a.AddPoliciesCount(sec, ptype, rules)
+	return err
+}
+
+// AddPoliciesCount adds the rules which aren't stored yet, and returns the
+// number of inserted rows. The stored rules are skipped before the insert,
+// and the insert does nothing on a conflict with the unique index, so that
+// replicas racing on the same rule store it once and don't fail. With
+// WithSkipMigration, the caller must create the index for this to hold.
+func (a *GormAdapter) AddPoliciesCount(sec string, ptype string, rules [][]string) (int64, error) {
+	lines := make([]Rule, 0, len(rules))
+	keys := map[string]struct{}{}
 	for _, rule := range rules {
 		line := a.savePolicyLine(ptype, rule)
+		if _, ok := keys[lineKey(line)]; ok {
+			continue
+		}
+		keys[lineKey(line)] = struct{}{}
 		lines = append(lines, line)
 	}
-	return a.db.Create(&lines).Error
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	var count int64
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		stored, err := a.storedKeys(tx, ptype, lines)
+		if err != nil {
+			return err
+		}
+
+		missing := make([]Rule, 0, len(lines))
+		for _, line := range lines {
+			if _, ok := stored[lineKey(line)]; !ok {
+				missing = append(missing, line)
+			}
+		}
+
+		for len(missing) > 0 {
+			n := len(missing)
+			if n > batchSize {
+				n = batchSize
+			}
+			batch := missing[:n]
+			res := tx.Scopes(a.ruleTable()).Clauses(clause.OnConflict{DoNothing: true}).Create(&batch)
+			if res.Error != nil {
+				return res.Error
+			}
+			count += res.RowsAffected
+			missing = missing[n:]
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// storedKeys returns the keys of the lines which are stored.
+func (a *GormAdapter) storedKeys(db *gorm.DB, ptype string, lines []Rule) (map[string]struct{}, error) {
+	v0s := make([]string, 0, len(lines))
+	seen := map[string]struct{}{}
+	for _, line := range lines {
+		if _, ok := seen[line.V0]; !ok {
+			seen[line.V0] = struct{}{}
+			v0s = append(v0s, line.V0)
+		}
+	}
+
+	stored := map[string]struct{}{}
+	for len(v0s) > 0 {
+		n := len(v0s)
+		if n > batchSize {
+			n = batchSize
+		}
+
+		var rows []Rule
+		if err := db.Scopes(a.ruleTable()).Where("ptype = ? AND v0 IN ?", ptype, v0s[:n]).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			stored[lineKey(row)] = struct{}{}
+		}
+		v0s = v0s[n:]
+	}
+	return stored, nil
+}
+
+// lineKey returns the fields of the line, including the empty ones.
+func lineKey(line Rule) string {
+	return strings.Join([]string{line.PType, line.V0, line.V1, line.V2, line.V3, line.V4, line.V5}, "\x00")
 }
 
 // Transaction perform a set of operations within a transaction
@@ -301,12 +557,12 @@ func (a *GormAdapter) Transaction(e casbin.IEnforcer, fc func(casbin.IEnforcer)
 	oriAdapter := a.db
 	// reload policy from database to sync with the transaction
 	defer func() {
-		e.SetAdapter(&GormAdapter{db: oriAdapter})
+		e.SetAdapter(a.withDB(oriAdapter))
 		err = e.LoadPolicy()
 	}()
 	copyDB := *a.db
 	tx := copyDB.Begin(opts...)
-	b := &GormAdapter{db: tx}
+	b := a.withDB(tx)
 	// copy enforcer to set the new adapter with transaction tx
 	copyEnforcer := e
 	copyEnforcer.SetAdapter(b)
@@ -322,17 +578,39 @@ func (a *GormAdapter) Transaction(e casbin.IEnforcer, fc func(casbin.IEnforcer)
 	return nil
 }
 
+// WithTransaction calls fn with a copy of the adapter which writes in a
+// transaction, committed when fn returns nil.
+func (a *GormAdapter) WithTransaction(fn func(tx persist.Adapter) error) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		return fn(a.withDB(tx))
+	})
+}
+
 // RemovePolicies removes multiple policy rules from the storage.
 func (a *GormAdapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
-	return a.db.Transaction(func(tx *gorm.DB) error {
+	_, err := a.RemovePoliciesCount(sec, ptype, rules)
+	return err
+}
+
+// RemovePoliciesCount removes multiple policy rules from the storage, and
+// returns the number of deleted rows.
+func (a *GormAdapter) RemovePoliciesCount(sec string, ptype string, rules [][]string) (int64, error) {
+	var count int64
+	err := a.db.Transaction(func(tx *gorm.DB) error {
 		for _, rule := range rules {
 			line := a.savePolicyLine(ptype, rule)
-			if err := a.rawDelete(tx, line); err != nil { //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
+			n, err := a.rawDelete(tx, line) //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
+			if err != nil {
 				return err
 			}
+			count += n
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
 // RemoveFilteredPolicy removes policy rules that match the filter from the storage.
@@ -342,7 +620,8 @@ func (a *GormAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex
 	line.PType = ptype
 
 	if fieldIndex == -1 {
-		return a.rawDelete(a.db, *line)
+		_, err := a.rawDelete(a.db, *line)
+		return err
 	}
 
 	err := checkQueryField(fieldValues)
@@ -368,11 +647,13 @@ func (a *GormAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex
 	if fieldIndex <= 5 && 5 < fieldIndex+len(fieldValues) {
 		line.V5 = fieldValues[5-fieldIndex]
 	}
-	err = a.rawDelete(a.db, *line)
+	_, err = a.rawDelete(a.db, *line)
 	return err
 }
 
-func (a *GormAdapter) rawDelete(db *gorm.DB, line Rule) error {
+// rawDelete deletes the rows which match the non empty fields of the line,
+// and returns their number.
+func (a *GormAdapter) rawDelete(db *gorm.DB, line Rule) (int64, error) {
 	queryArgs := []interface{}{line.PType}
 
 	queryStr := "ptype = ?"
@@ -401,15 +682,15 @@ func (a *GormAdapter) rawDelete(db *gorm.DB, line Rule) error {
 		queryArgs = append(queryArgs, line.V5)
 	}
 	args := append([]interface{}{queryStr}, queryArgs...)
-	err := db.Delete(a.getTableInstance(), args...).Error
-	return err
+	tx := db.Scopes(a.ruleTable()).Delete(a.getTableInstance(), args...)
+	return tx.RowsAffected, tx.Error
 }
 
 // UpdatePolicy updates a new policy rule to DB.
 func (a *GormAdapter) UpdatePolicy(sec string, ptype string, oldRule, newPolicy []string) error {
 	oldLine := a.savePolicyLine(ptype, oldRule)
 	newLine := a.savePolicyLine(ptype, newPolicy)
-	return a.db.Model(&oldLine).Where(&oldLine).Updates(newLine).Error
+	return a.db.Scopes(a.ruleTable()).Where(&oldLine).Updates(newLine).Error
 }
 
 func (a *GormAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
@@ -421,14 +702,15 @@ func (a *GormAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRule
 	for _, newRule := range newRules {
 		newPolicies = append(newPolicies, a.savePolicyLine(ptype, newRule))
 	}
-	tx := a.db.Begin()
-	for i := range oldPolicies {
-		if err := tx.Model(&oldPolicies[i]).Where(&oldPolicies[i]).Updates(newPolicies[i]).Error; err != nil {
-			tx.Rollback()
-			return err
+	// a.db.Transaction nests in the transaction of WithTransaction
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		for i := range oldPolicies {
+			if err := tx.Scopes(a.ruleTable()).Where(&oldPolicies[i]).Updates(newPolicies[i]).Error; err != nil {
+				return err
+			}
 		}
-	}
-	return tx.Commit().Error
+		return nil
+	})
 }
 
 func (a *GormAdapter) UpdateFilteredPolicies(sec string, ptype string, newPolicies [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
@@ -461,21 +743,23 @@ func (a *GormAdapter) UpdateFilteredPolicies(sec string, ptype string, newPolici
 		newP = append(newP, a.savePolicyLine(ptype, newRule))
 	}
 
-	tx := a.db.Begin()
 	str, args := line.queryString()
-	if err := tx.Where(str, args...).Find(&oldP).Error; err != nil {
-		tx.Rollback()
-		return nil, err
-	}
-	if err := tx.Where(str, args...).Delete([]Rule{}).Error; err != nil {
-		tx.Rollback()
-		return nil, err
-	}
-	for i := range newP {
-		if err := tx.Create(&newP[i]).Error; err != nil {
-			tx.Rollback()
-			return nil, err
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(a.ruleTable()).Where(str, args...).Find(&oldP).Error; err != nil {
+			return err
 		}
+		if err := tx.Scopes(a.ruleTable()).Where(str, args...).Delete([]Rule{}).Error; err != nil {
+			return err
+		}
+		for i := range newP {
+			if err := tx.Scopes(a.ruleTable()).Clauses(clause.OnConflict{DoNothing: true}).Create(&newP[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// return deleted rulues
@@ -484,7 +768,7 @@ func (a *GormAdapter) UpdateFilteredPolicies(sec string, ptype string, newPolici
 		oldPolicy := v.toStringPolicy()
 		oldPolicies = append(oldPolicies, oldPolicy)
 	}
-	return oldPolicies, tx.Commit().Error
+	return oldPolicies, nil
 }
 
 // Preview Pre-checking to avoid causing partial load success and partial failure deep
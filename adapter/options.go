@@ -0,0 +1,124 @@
+// MIT License
+//
+// Copyright (c) 2023 Lack
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package adapter
+
+import "time"
+
+// Options configures an adapter.
+type Options struct {
+	// TableName is the table of the rules, rbac_rule by default. The versions
+	// are stored in rbac_version, or in the table name followed by "_version"
+	// when it is set.
+	TableName string
+	// TablePrefix is prepended to the tables of the rules and of the versions,
+	// followed by "_", so that several policy stores can share a database.
+	// For etcd, it is the prefix of the keys, /rbac by default.
+	TablePrefix string
+	// SkipMigration leaves the tables to be created by the caller.
+	SkipMigration bool
+	// UniqueIndex creates a unique index on the fields of the rules, true by
+	// default, see WithoutUniqueIndex.
+	UniqueIndex bool
+
+	// ConnectTimeout bounds the connection check of the etcd adapter,
+	// DefaultConnectTimeout by default.
+	ConnectTimeout time.Duration
+	// RequestTimeout bounds each operation of the etcd adapter, there is no
+	// timeout by default.
+	RequestTimeout time.Duration
+	// Namespace is prepended to every etcd key with a namespace.KV, so that
+	// the client sees nothing outside of it.
+	Namespace string
+}
+
+// Option sets an option of Options.
+type Option func(*Options)
+
+func newOptions(opts ...Option) Options {
+	options := Options{TableName: (&Rule{}).TableName(), UniqueIndex: true}
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// versionTableName returns the table of the versions of the rules stored in
+// tableName, so that the stores with different tables keep their own versions.
+func versionTableName(tableName string) string {
+	if tableName == (&Rule{}).TableName() {
+		return (&Version{}).TableName()
+	}
+	return tableName + "_version"
+}
+
+// WithTableName sets the table of the rules.
+func WithTableName(name string) Option {
+	return func(o *Options) {
+		o.TableName = name
+	}
+}
+
+// WithTablePrefix sets the prefix of the tables.
+func WithTablePrefix(prefix string) Option {
+	return func(o *Options) {
+		o.TablePrefix = prefix
+	}
+}
+
+// WithSkipMigration disables the migration of the tables.
+func WithSkipMigration() Option {
+	return func(o *Options) {
+		o.SkipMigration = true
+	}
+}
+
+// WithoutUniqueIndex doesn't create the unique index on the fields of the
+// rules, so that the database accepts duplicate rules stored behind the
+// adapter's back.
+func WithoutUniqueIndex() Option {
+	return func(o *Options) {
+		o.UniqueIndex = false
+	}
+}
+
+// WithConnectTimeout sets the timeout of the connection check of the etcd
+// adapter.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.ConnectTimeout = timeout
+	}
+}
+
+// WithRequestTimeout sets the timeout of each operation of the etcd adapter.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.RequestTimeout = timeout
+	}
+}
+
+// WithNamespace isolates the keys of the etcd adapter under the namespace.
+func WithNamespace(ns string) Option {
+	return func(o *Options) {
+		o.Namespace = ns
+	}
+}
@@ -9,6 +9,7 @@ import (
 	proto "github.com/gogo/protobuf/proto"
 	client "github.com/vine-io/vine/core/client"
 	server "github.com/vine-io/vine/core/server"
+	_ "github.com/vine-io/vine/lib/api"
 	api "github.com/vine-io/vine/lib/api"
 	openapi "github.com/vine-io/vine/lib/api/handler/openapi"
 	openapipb "github.com/vine-io/vine/lib/api/handler/openapi/proto"
@@ -66,6 +67,27 @@ type RBACService interface {
 	AddGroupPolicy(ctx context.Context, in *AddGroupPolicyRequest, opts ...client.CallOption) (*AddGroupPolicyResponse, error)
 	DelGroupPolicy(ctx context.Context, in *DelGroupPolicyRequest, opts ...client.CallOption) (*DelGroupPolicyResponse, error)
 	Enforce(ctx context.Context, in *EnforceRequest, opts ...client.CallOption) (*EnforceResponse, error)
+	DeleteSubject(ctx context.Context, in *DeleteSubjectRequest, opts ...client.CallOption) (*DeleteSubjectResponse, error)
+	DeleteRole(ctx context.Context, in *DeleteRoleRequest, opts ...client.CallOption) (*DeleteRoleResponse, error)
+	RenameSubject(ctx context.Context, in *RenameSubjectRequest, opts ...client.CallOption) (*RenameSubjectResponse, error)
+	ListPolicies(ctx context.Context, in *ListPoliciesRequest, opts ...client.CallOption) (*ListPoliciesResponse, error)
+	ListSubjects(ctx context.Context, in *ListSubjectsRequest, opts ...client.CallOption) (*ListSubjectsResponse, error)
+	GetSubjectsForEndpoint(ctx context.Context, in *GetSubjectsForEndpointRequest, opts ...client.CallOption) (*GetSubjectsForEndpointResponse, error)
+	ExportMatrix(ctx context.Context, in *ExportMatrixRequest, opts ...client.CallOption) (RBACService_ExportMatrixService, error)
+	ImportPolicies(ctx context.Context, opts ...client.CallOption) (RBACService_ImportPoliciesService, error)
+	ExportPolicies(ctx context.Context, in *ExportPoliciesRequest, opts ...client.CallOption) (RBACService_ExportPoliciesService, error)
+	Reconcile(ctx context.Context, in *ReconcileRequest, opts ...client.CallOption) (*ReconcileResponse, error)
+	ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...client.CallOption) (*ListVersionsResponse, error)
+	GetPolicyAt(ctx context.Context, in *GetPolicyAtRequest, opts ...client.CallOption) (*GetPolicyAtResponse, error)
+	DiffVersions(ctx context.Context, in *DiffVersionsRequest, opts ...client.CallOption) (*DiffVersionsResponse, error)
+	Rollback(ctx context.Context, in *RollbackRequest, opts ...client.CallOption) (*RollbackResponse, error)
+	QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, opts ...client.CallOption) (*QueryAuditLogResponse, error)
+	WatchPolicies(ctx context.Context, in *WatchPoliciesRequest, opts ...client.CallOption) (RBACService_WatchPoliciesService, error)
+	AddWebhook(ctx context.Context, in *AddWebhookRequest, opts ...client.CallOption) (*AddWebhookResponse, error)
+	DelWebhook(ctx context.Context, in *DelWebhookRequest, opts ...client.CallOption) (*DelWebhookResponse, error)
+	ListWebhooks(ctx context.Context, in *ListWebhooksRequest, opts ...client.CallOption) (*ListWebhooksResponse, error)
+	ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...client.CallOption) (*ListDeadLettersResponse, error)
+	RedeliverDeadLetter(ctx context.Context, in *RedeliverDeadLetterRequest, opts ...client.CallOption) (*RedeliverDeadLetterResponse, error)
 }
 
 type rBACService struct {
@@ -160,6 +182,369 @@ func (c *rBACService) Enforce(ctx context.Context, in *EnforceRequest, opts ...c
 	return out, nil
 }
 
+func (c *rBACService) DeleteSubject(ctx context.Context, in *DeleteSubjectRequest, opts ...client.CallOption) (*DeleteSubjectResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.DeleteSubject", in)
+	out := new(DeleteSubjectResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) DeleteRole(ctx context.Context, in *DeleteRoleRequest, opts ...client.CallOption) (*DeleteRoleResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.DeleteRole", in)
+	out := new(DeleteRoleResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) RenameSubject(ctx context.Context, in *RenameSubjectRequest, opts ...client.CallOption) (*RenameSubjectResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.RenameSubject", in)
+	out := new(RenameSubjectResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) ListPolicies(ctx context.Context, in *ListPoliciesRequest, opts ...client.CallOption) (*ListPoliciesResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.ListPolicies", in)
+	out := new(ListPoliciesResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) ListSubjects(ctx context.Context, in *ListSubjectsRequest, opts ...client.CallOption) (*ListSubjectsResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.ListSubjects", in)
+	out := new(ListSubjectsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) GetSubjectsForEndpoint(ctx context.Context, in *GetSubjectsForEndpointRequest, opts ...client.CallOption) (*GetSubjectsForEndpointResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.GetSubjectsForEndpoint", in)
+	out := new(GetSubjectsForEndpointResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) ExportMatrix(ctx context.Context, in *ExportMatrixRequest, opts ...client.CallOption) (RBACService_ExportMatrixService, error) {
+	req := c.c.NewRequest(c.name, "RBACService.ExportMatrix", &ExportMatrixRequest{})
+	stream, err := c.c.Stream(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(in); err != nil {
+		return nil, err
+	}
+	return &rBACServiceExportMatrix{stream}, nil
+}
+
+type RBACService_ExportMatrixService interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Recv() (*ExportMatrixResponse, error)
+}
+
+type rBACServiceExportMatrix struct {
+	stream client.Stream
+}
+
+func (x *rBACServiceExportMatrix) Close() error {
+	return x.stream.Close()
+}
+
+func (x *rBACServiceExportMatrix) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *rBACServiceExportMatrix) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *rBACServiceExportMatrix) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *rBACServiceExportMatrix) Recv() (*ExportMatrixResponse, error) {
+	m := new(ExportMatrixResponse)
+	err := x.stream.Recv(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *rBACService) ImportPolicies(ctx context.Context, opts ...client.CallOption) (RBACService_ImportPoliciesService, error) {
+	req := c.c.NewRequest(c.name, "RBACService.ImportPolicies", &ImportPoliciesRequest{})
+	stream, err := c.c.Stream(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &rBACServiceImportPolicies{stream}, nil
+}
+
+type RBACService_ImportPoliciesService interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	CloseAndRecv() (*ImportPoliciesResponse, error)
+	Send(*ImportPoliciesRequest) error
+}
+
+type rBACServiceImportPolicies struct {
+	stream client.Stream
+}
+
+func (x *rBACServiceImportPolicies) CloseAndRecv() (*ImportPoliciesResponse, error) {
+	if err := x.stream.Close(); err != nil {
+		return nil, err
+	}
+	r := new(ImportPoliciesResponse)
+	err := x.RecvMsg(r)
+	return r, err
+}
+
+func (x *rBACServiceImportPolicies) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *rBACServiceImportPolicies) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *rBACServiceImportPolicies) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *rBACServiceImportPolicies) Send(m *ImportPoliciesRequest) error {
+	return x.stream.Send(m)
+}
+
+func (c *rBACService) ExportPolicies(ctx context.Context, in *ExportPoliciesRequest, opts ...client.CallOption) (RBACService_ExportPoliciesService, error) {
+	req := c.c.NewRequest(c.name, "RBACService.ExportPolicies", &ExportPoliciesRequest{})
+	stream, err := c.c.Stream(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(in); err != nil {
+		return nil, err
+	}
+	return &rBACServiceExportPolicies{stream}, nil
+}
+
+type RBACService_ExportPoliciesService interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Recv() (*ExportPoliciesResponse, error)
+}
+
+type rBACServiceExportPolicies struct {
+	stream client.Stream
+}
+
+func (x *rBACServiceExportPolicies) Close() error {
+	return x.stream.Close()
+}
+
+func (x *rBACServiceExportPolicies) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *rBACServiceExportPolicies) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *rBACServiceExportPolicies) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *rBACServiceExportPolicies) Recv() (*ExportPoliciesResponse, error) {
+	m := new(ExportPoliciesResponse)
+	err := x.stream.Recv(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *rBACService) Reconcile(ctx context.Context, in *ReconcileRequest, opts ...client.CallOption) (*ReconcileResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.Reconcile", in)
+	out := new(ReconcileResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...client.CallOption) (*ListVersionsResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.ListVersions", in)
+	out := new(ListVersionsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) GetPolicyAt(ctx context.Context, in *GetPolicyAtRequest, opts ...client.CallOption) (*GetPolicyAtResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.GetPolicyAt", in)
+	out := new(GetPolicyAtResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) DiffVersions(ctx context.Context, in *DiffVersionsRequest, opts ...client.CallOption) (*DiffVersionsResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.DiffVersions", in)
+	out := new(DiffVersionsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) Rollback(ctx context.Context, in *RollbackRequest, opts ...client.CallOption) (*RollbackResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.Rollback", in)
+	out := new(RollbackResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, opts ...client.CallOption) (*QueryAuditLogResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.QueryAuditLog", in)
+	out := new(QueryAuditLogResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) WatchPolicies(ctx context.Context, in *WatchPoliciesRequest, opts ...client.CallOption) (RBACService_WatchPoliciesService, error) {
+	req := c.c.NewRequest(c.name, "RBACService.WatchPolicies", &WatchPoliciesRequest{})
+	stream, err := c.c.Stream(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(in); err != nil {
+		return nil, err
+	}
+	return &rBACServiceWatchPolicies{stream}, nil
+}
+
+type RBACService_WatchPoliciesService interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Recv() (*WatchPoliciesResponse, error)
+}
+
+type rBACServiceWatchPolicies struct {
+	stream client.Stream
+}
+
+func (x *rBACServiceWatchPolicies) Close() error {
+	return x.stream.Close()
+}
+
+func (x *rBACServiceWatchPolicies) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *rBACServiceWatchPolicies) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *rBACServiceWatchPolicies) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *rBACServiceWatchPolicies) Recv() (*WatchPoliciesResponse, error) {
+	m := new(WatchPoliciesResponse)
+	err := x.stream.Recv(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *rBACService) AddWebhook(ctx context.Context, in *AddWebhookRequest, opts ...client.CallOption) (*AddWebhookResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.AddWebhook", in)
+	out := new(AddWebhookResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) DelWebhook(ctx context.Context, in *DelWebhookRequest, opts ...client.CallOption) (*DelWebhookResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.DelWebhook", in)
+	out := new(DelWebhookResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) ListWebhooks(ctx context.Context, in *ListWebhooksRequest, opts ...client.CallOption) (*ListWebhooksResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.ListWebhooks", in)
+	out := new(ListWebhooksResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...client.CallOption) (*ListDeadLettersResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.ListDeadLetters", in)
+	out := new(ListDeadLettersResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rBACService) RedeliverDeadLetter(ctx context.Context, in *RedeliverDeadLetterRequest, opts ...client.CallOption) (*RedeliverDeadLetterResponse, error) {
+	req := c.c.NewRequest(c.name, "RBACService.RedeliverDeadLetter", in)
+	out := new(RedeliverDeadLetterResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for RBACService service
 // +gen:openapi
 type RBACServiceHandler interface {
@@ -171,6 +556,27 @@ type RBACServiceHandler interface {
 	AddGroupPolicy(context.Context, *AddGroupPolicyRequest, *AddGroupPolicyResponse) error
 	DelGroupPolicy(context.Context, *DelGroupPolicyRequest, *DelGroupPolicyResponse) error
 	Enforce(context.Context, *EnforceRequest, *EnforceResponse) error
+	DeleteSubject(context.Context, *DeleteSubjectRequest, *DeleteSubjectResponse) error
+	DeleteRole(context.Context, *DeleteRoleRequest, *DeleteRoleResponse) error
+	RenameSubject(context.Context, *RenameSubjectRequest, *RenameSubjectResponse) error
+	ListPolicies(context.Context, *ListPoliciesRequest, *ListPoliciesResponse) error
+	ListSubjects(context.Context, *ListSubjectsRequest, *ListSubjectsResponse) error
+	GetSubjectsForEndpoint(context.Context, *GetSubjectsForEndpointRequest, *GetSubjectsForEndpointResponse) error
+	ExportMatrix(context.Context, *ExportMatrixRequest, RBACService_ExportMatrixStream) error
+	ImportPolicies(context.Context, RBACService_ImportPoliciesStream) error
+	ExportPolicies(context.Context, *ExportPoliciesRequest, RBACService_ExportPoliciesStream) error
+	Reconcile(context.Context, *ReconcileRequest, *ReconcileResponse) error
+	ListVersions(context.Context, *ListVersionsRequest, *ListVersionsResponse) error
+	GetPolicyAt(context.Context, *GetPolicyAtRequest, *GetPolicyAtResponse) error
+	DiffVersions(context.Context, *DiffVersionsRequest, *DiffVersionsResponse) error
+	Rollback(context.Context, *RollbackRequest, *RollbackResponse) error
+	QueryAuditLog(context.Context, *QueryAuditLogRequest, *QueryAuditLogResponse) error
+	WatchPolicies(context.Context, *WatchPoliciesRequest, RBACService_WatchPoliciesStream) error
+	AddWebhook(context.Context, *AddWebhookRequest, *AddWebhookResponse) error
+	DelWebhook(context.Context, *DelWebhookRequest, *DelWebhookResponse) error
+	ListWebhooks(context.Context, *ListWebhooksRequest, *ListWebhooksResponse) error
+	ListDeadLetters(context.Context, *ListDeadLettersRequest, *ListDeadLettersResponse) error
+	RedeliverDeadLetter(context.Context, *RedeliverDeadLetterRequest, *RedeliverDeadLetterResponse) error
 }
 
 func RegisterRBACServiceHandler(s server.Server, hdlr RBACServiceHandler, opts ...server.HandlerOption) error {
@@ -183,6 +589,27 @@ func RegisterRBACServiceHandler(s server.Server, hdlr RBACServiceHandler, opts .
 		AddGroupPolicy(ctx context.Context, in *AddGroupPolicyRequest, out *AddGroupPolicyResponse) error
 		DelGroupPolicy(ctx context.Context, in *DelGroupPolicyRequest, out *DelGroupPolicyResponse) error
 		Enforce(ctx context.Context, in *EnforceRequest, out *EnforceResponse) error
+		DeleteSubject(ctx context.Context, in *DeleteSubjectRequest, out *DeleteSubjectResponse) error
+		DeleteRole(ctx context.Context, in *DeleteRoleRequest, out *DeleteRoleResponse) error
+		RenameSubject(ctx context.Context, in *RenameSubjectRequest, out *RenameSubjectResponse) error
+		ListPolicies(ctx context.Context, in *ListPoliciesRequest, out *ListPoliciesResponse) error
+		ListSubjects(ctx context.Context, in *ListSubjectsRequest, out *ListSubjectsResponse) error
+		GetSubjectsForEndpoint(ctx context.Context, in *GetSubjectsForEndpointRequest, out *GetSubjectsForEndpointResponse) error
+		ExportMatrix(ctx context.Context, stream server.Stream) error
+		ImportPolicies(ctx context.Context, stream server.Stream) error
+		ExportPolicies(ctx context.Context, stream server.Stream) error
+		Reconcile(ctx context.Context, in *ReconcileRequest, out *ReconcileResponse) error
+		ListVersions(ctx context.Context, in *ListVersionsRequest, out *ListVersionsResponse) error
+		GetPolicyAt(ctx context.Context, in *GetPolicyAtRequest, out *GetPolicyAtResponse) error
+		DiffVersions(ctx context.Context, in *DiffVersionsRequest, out *DiffVersionsResponse) error
+		Rollback(ctx context.Context, in *RollbackRequest, out *RollbackResponse) error
+		QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, out *QueryAuditLogResponse) error
+		WatchPolicies(ctx context.Context, stream server.Stream) error
+		AddWebhook(ctx context.Context, in *AddWebhookRequest, out *AddWebhookResponse) error
+		DelWebhook(ctx context.Context, in *DelWebhookRequest, out *DelWebhookResponse) error
+		ListWebhooks(ctx context.Context, in *ListWebhooksRequest, out *ListWebhooksResponse) error
+		ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, out *ListDeadLettersResponse) error
+		RedeliverDeadLetter(ctx context.Context, in *RedeliverDeadLetterRequest, out *RedeliverDeadLetterResponse) error
 	}
 	type RBACService struct {
 		rBACServiceImpl
@@ -232,3 +659,234 @@ func (h *rBACServiceHandler) DelGroupPolicy(ctx context.Context, in *DelGroupPol
 func (h *rBACServiceHandler) Enforce(ctx context.Context, in *EnforceRequest, out *EnforceResponse) error {
 	return h.RBACServiceHandler.Enforce(ctx, in, out)
 }
+
+func (h *rBACServiceHandler) DeleteSubject(ctx context.Context, in *DeleteSubjectRequest, out *DeleteSubjectResponse) error {
+	return h.RBACServiceHandler.DeleteSubject(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) DeleteRole(ctx context.Context, in *DeleteRoleRequest, out *DeleteRoleResponse) error {
+	return h.RBACServiceHandler.DeleteRole(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) RenameSubject(ctx context.Context, in *RenameSubjectRequest, out *RenameSubjectResponse) error {
+	return h.RBACServiceHandler.RenameSubject(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) ListPolicies(ctx context.Context, in *ListPoliciesRequest, out *ListPoliciesResponse) error {
+	return h.RBACServiceHandler.ListPolicies(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) ListSubjects(ctx context.Context, in *ListSubjectsRequest, out *ListSubjectsResponse) error {
+	return h.RBACServiceHandler.ListSubjects(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) GetSubjectsForEndpoint(ctx context.Context, in *GetSubjectsForEndpointRequest, out *GetSubjectsForEndpointResponse) error {
+	return h.RBACServiceHandler.GetSubjectsForEndpoint(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) ExportMatrix(ctx context.Context, stream server.Stream) error {
+	m := new(ExportMatrixRequest)
+	if err := stream.Recv(m); err != nil {
+		return err
+	}
+	return h.RBACServiceHandler.ExportMatrix(ctx, m, &rBACServiceExportMatrixStream{stream})
+}
+
+type RBACService_ExportMatrixStream interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Send(*ExportMatrixResponse) error
+}
+
+type rBACServiceExportMatrixStream struct {
+	stream server.Stream
+}
+
+func (x *rBACServiceExportMatrixStream) Close() error {
+	return x.stream.Close()
+}
+
+func (x *rBACServiceExportMatrixStream) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *rBACServiceExportMatrixStream) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *rBACServiceExportMatrixStream) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *rBACServiceExportMatrixStream) Send(m *ExportMatrixResponse) error {
+	return x.stream.Send(m)
+}
+
+func (h *rBACServiceHandler) ImportPolicies(ctx context.Context, stream server.Stream) error {
+	return h.RBACServiceHandler.ImportPolicies(ctx, &rBACServiceImportPoliciesStream{stream})
+}
+
+type RBACService_ImportPoliciesStream interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	SendAndClose(*ImportPoliciesResponse) error
+	Recv() (*ImportPoliciesRequest, error)
+}
+
+type rBACServiceImportPoliciesStream struct {
+	stream server.Stream
+}
+
+func (x *rBACServiceImportPoliciesStream) SendAndClose(in *ImportPoliciesResponse) error {
+	if err := x.SendMsg(in); err != nil {
+		return err
+	}
+	return x.stream.Close()
+}
+
+func (x *rBACServiceImportPoliciesStream) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *rBACServiceImportPoliciesStream) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *rBACServiceImportPoliciesStream) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *rBACServiceImportPoliciesStream) Recv() (*ImportPoliciesRequest, error) {
+	m := new(ImportPoliciesRequest)
+	if err := x.stream.Recv(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (h *rBACServiceHandler) ExportPolicies(ctx context.Context, stream server.Stream) error {
+	m := new(ExportPoliciesRequest)
+	if err := stream.Recv(m); err != nil {
+		return err
+	}
+	return h.RBACServiceHandler.ExportPolicies(ctx, m, &rBACServiceExportPoliciesStream{stream})
+}
+
+type RBACService_ExportPoliciesStream interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Send(*ExportPoliciesResponse) error
+}
+
+type rBACServiceExportPoliciesStream struct {
+	stream server.Stream
+}
+
+func (x *rBACServiceExportPoliciesStream) Close() error {
+	return x.stream.Close()
+}
+
+func (x *rBACServiceExportPoliciesStream) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *rBACServiceExportPoliciesStream) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *rBACServiceExportPoliciesStream) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *rBACServiceExportPoliciesStream) Send(m *ExportPoliciesResponse) error {
+	return x.stream.Send(m)
+}
+
+func (h *rBACServiceHandler) Reconcile(ctx context.Context, in *ReconcileRequest, out *ReconcileResponse) error {
+	return h.RBACServiceHandler.Reconcile(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) ListVersions(ctx context.Context, in *ListVersionsRequest, out *ListVersionsResponse) error {
+	return h.RBACServiceHandler.ListVersions(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) GetPolicyAt(ctx context.Context, in *GetPolicyAtRequest, out *GetPolicyAtResponse) error {
+	return h.RBACServiceHandler.GetPolicyAt(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) DiffVersions(ctx context.Context, in *DiffVersionsRequest, out *DiffVersionsResponse) error {
+	return h.RBACServiceHandler.DiffVersions(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) Rollback(ctx context.Context, in *RollbackRequest, out *RollbackResponse) error {
+	return h.RBACServiceHandler.Rollback(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, out *QueryAuditLogResponse) error {
+	return h.RBACServiceHandler.QueryAuditLog(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) WatchPolicies(ctx context.Context, stream server.Stream) error {
+	m := new(WatchPoliciesRequest)
+	if err := stream.Recv(m); err != nil {
+		return err
+	}
+	return h.RBACServiceHandler.WatchPolicies(ctx, m, &rBACServiceWatchPoliciesStream{stream})
+}
+
+type RBACService_WatchPoliciesStream interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Send(*WatchPoliciesResponse) error
+}
+
+type rBACServiceWatchPoliciesStream struct {
+	stream server.Stream
+}
+
+func (x *rBACServiceWatchPoliciesStream) Close() error {
+	return x.stream.Close()
+}
+
+func (x *rBACServiceWatchPoliciesStream) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *rBACServiceWatchPoliciesStream) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *rBACServiceWatchPoliciesStream) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *rBACServiceWatchPoliciesStream) Send(m *WatchPoliciesResponse) error {
+	return x.stream.Send(m)
+}
+
+func (h *rBACServiceHandler) AddWebhook(ctx context.Context, in *AddWebhookRequest, out *AddWebhookResponse) error {
+	return h.RBACServiceHandler.AddWebhook(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) DelWebhook(ctx context.Context, in *DelWebhookRequest, out *DelWebhookResponse) error {
+	return h.RBACServiceHandler.DelWebhook(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) ListWebhooks(ctx context.Context, in *ListWebhooksRequest, out *ListWebhooksResponse) error {
+	return h.RBACServiceHandler.ListWebhooks(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, out *ListDeadLettersResponse) error {
+	return h.RBACServiceHandler.ListDeadLetters(ctx, in, out)
+}
+
+func (h *rBACServiceHandler) RedeliverDeadLetter(ctx context.Context, in *RedeliverDeadLetterRequest, out *RedeliverDeadLetterResponse) error {
+	return h.RBACServiceHandler.RedeliverDeadLetter(ctx, in, out)
+}
@@ -6,6 +6,10 @@ import (
 	"github.com/vine-io/vine/lib/api"
 )
 
+// ChangeTopic is the default topic of the broker on which the changes of the
+// rules are published as PolicyChange messages.
+const ChangeTopic = "go.vine.rbac.change"
+
 func (m PType) Name() string {
 	switch m {
 	case PType_POLICY:
@@ -0,0 +1,134 @@
+package rbac
+
+import (
+	"context"
+	"time"
+
+	"github.com/vine-io/vine/util/context/metadata"
+)
+
+// ActorKey is the key of the vine metadata which holds the subject making a
+// request, it is recorded as the actor of the mutations.
+var ActorKey = "Rbac-Actor"
+
+// AuditRecord is a rule changed by a mutation. The rules start with their
+// ptype, such as ["p", "alice", "data1", "read"].
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor,omitempty"`
+	Operation string    `json:"operation"`
+	Reason    string    `json:"reason,omitempty"`
+	// Before is the removed rule, empty when the rule is added.
+	Before []string `json:"before,omitempty"`
+	// After is the added rule, empty when the rule is removed.
+	After []string `json:"after,omitempty"`
+}
+
+// Rule returns the changed rule.
+func (r *AuditRecord) Rule() []string {
+	if len(r.After) > 0 {
+		return r.After
+	}
+	return r.Before
+}
+
+// AuditQuery selects the audit records. The zero value selects everything.
+type AuditQuery struct {
+	// Since matches the records made at or after it.
+	Since time.Time
+	// Until matches the records made before it.
+	Until time.Time
+	// Subject matches the records whose rule references it as a subject, a
+	// user or a group.
+	Subject string
+	// Limit is the maximum number of the returned records.
+	Limit int
+}
+
+// Match reports whether the record matches the query, regardless of Limit.
+func (q *AuditQuery) Match(record *AuditRecord) bool {
+	if !q.Since.IsZero() && record.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !record.Time.Before(q.Until) {
+		return false
+	}
+	if q.Subject == "" {
+		return true
+	}
+
+	rule := record.Rule()
+	if len(rule) > 1 && rule[1] == q.Subject {
+		return true
+	}
+	// the group of g and g2 rules
+	return len(rule) > 2 && rule[0] != "p" && rule[2] == q.Subject
+}
+
+// AuditSink stores the audit records.
+type AuditSink interface {
+	// Write stores the records of a mutation.
+	Write(ctx context.Context, records ...*AuditRecord) error
+	// Query returns the records which match the query, the latest first.
+	Query(ctx context.Context, query AuditQuery) ([]*AuditRecord, error)
+}
+
+type reasonKey struct{}
+
+// WithReason returns a context carrying the reason of the mutations made
+// with it, which is recorded in the audit log.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// Reason returns the reason carried by the context.
+func Reason(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	return reason
+}
+
+// Actor returns the subject making the request, from the vine metadata of
+// the context.
+func Actor(ctx context.Context) string {
+	actor, _ := metadata.Get(ctx, ActorKey)
+	return actor
+}
+
+// QueryAuditLog returns the audit records which match the query.
+func (r *rbac) QueryAuditLog(ctx context.Context, query AuditQuery) ([]*AuditRecord, error) {
+	if r.audit == nil {
+		return nil, ErrAuditDisabled
+	}
+
+	query.Limit = pageLimit(query.Limit)
+	return r.audit.Query(ctx, query)
+}
+
+// auditRecords returns the records of the changes made by an operation.
+func auditRecords(ctx context.Context, operation string, changes []ruleChange) []*AuditRecord {
+	now := time.Now()
+	actor, reason := Actor(ctx), Reason(ctx)
+
+	records := make([]*AuditRecord, 0)
+	for _, c := range changes {
+		for _, rule := range c.removed {
+			records = append(records, &AuditRecord{
+				Time:      now,
+				Actor:     actor,
+				Operation: operation,
+				Reason:    reason,
+				Before:    append([]string{c.ptype}, rule...),
+			})
+		}
+		for _, rule := range c.added {
+			records = append(records, &AuditRecord{
+				Time:      now,
+				Actor:     actor,
+				Operation: operation,
+				Reason:    reason,
+				After:     append([]string{c.ptype}, rule...),
+			})
+		}
+	}
+	return records
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// etcdPageSize is the number of keys read by each request of Query.
+	etcdPageSize = 256
+	// maxTxnOps is the default limit of operations in a single etcd
+	// transaction.
+	maxTxnOps = 128
+)
+
+// EtcdSink stores the audit records as JSON values under keys ordered by time.
+type EtcdSink struct {
+	prefix string
+	conn   *clientv3.Client
+	seq    uint32
+}
+
+var _ rbac.AuditSink = (*EtcdSink)(nil)
+
+// NewEtcdSink is the constructor for EtcdSink, the records are stored
+// under prefix, such as "/rbac/rbac_audit".
+func NewEtcdSink(conn *clientv3.Client, prefix string) *EtcdSink {
+	return &EtcdSink{prefix: path.Clean("/" + prefix), conn: conn}
+}
+
+// key returns the key of a record made at t, the sequence number keeps the
+// keys of the records made at the same time unique.
+func (s *EtcdSink) key(t time.Time, seq uint32) string {
+	return fmt.Sprintf("%s/%020d-%010d", s.prefix, t.UnixNano(), seq)
+}
+
+// Write stores the records in transactions of at most maxTxnOps records, as
+// etcd limits the operations of a transaction. When a transaction fails, the
+// records of the previous ones are kept.
+func (s *EtcdSink) Write(ctx context.Context, records ...*rbac.AuditRecord) error {
+	ops := make([]clientv3.Op, 0, len(records))
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, clientv3.OpPut(s.key(record.Time, atomic.AddUint32(&s.seq, 1)), string(data)))
+	}
+
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		if _, err := s.conn.Txn(ctx).Then(ops[:n]...).Commit(); err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// Query returns the records which match the query, the latest first.
+func (s *EtcdSink) Query(ctx context.Context, query rbac.AuditQuery) ([]*rbac.AuditRecord, error) {
+	from := s.prefix + "/"
+	if !query.Since.IsZero() {
+		from = s.key(query.Since, 0)
+	}
+	end := clientv3.GetPrefixRangeEnd(s.prefix + "/")
+	if !query.Until.IsZero() {
+		end = s.key(query.Until, 0)
+	}
+
+	records := make([]*rbac.AuditRecord, 0)
+	for from < end {
+		rsp, err := s.conn.Get(ctx, from, clientv3.WithRange(end), clientv3.WithLimit(etcdPageSize),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, kv := range rsp.Kvs {
+			record := &rbac.AuditRecord{}
+			if err = json.Unmarshal(kv.Value, record); err != nil {
+				return nil, err
+			}
+			if !query.Match(record) {
+				continue
+			}
+			records = append(records, record)
+			if query.Limit > 0 && len(records) == query.Limit {
+				return records, nil
+			}
+		}
+
+		if !rsp.More {
+			break
+		}
+		// the keys are read from the latest one
+		end = string(rsp.Kvs[len(rsp.Kvs)-1].Key)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"gorm.io/gorm"
+)
+
+// Record is a row of the rbac_audit table.
+type Record struct {
+	ID        uint      `gorm:"column:id;primaryKey;autoIncrement"`
+	Time      time.Time `gorm:"column:created_at;index"`
+	Actor     string    `gorm:"column:actor;size:100"`
+	Operation string    `gorm:"column:operation;size:100"`
+	Reason    string    `gorm:"column:reason"`
+	// Sub and Grp hold the subject, user and group of the rule, so that the
+	// records of a subject can be queried.
+	Sub    string   `gorm:"column:sub;size:100;index"`
+	Grp    string   `gorm:"column:grp;size:100;index"`
+	Before []string `gorm:"column:old_rule;type:text;serializer:json"`
+	After  []string `gorm:"column:new_rule;type:text;serializer:json"`
+}
+
+func (Record) TableName() string {
+	return "rbac_audit"
+}
+
+// GormSink stores the audit records in the rbac_audit table.
+type GormSink struct {
+	db *gorm.DB
+}
+
+var _ rbac.AuditSink = (*GormSink)(nil)
+
+// NewGormSink is the constructor for GormSink.
+func NewGormSink(db *gorm.DB) (*GormSink, error) {
+	if err := db.AutoMigrate(&Record{}); err != nil {
+		return nil, err
+	}
+
+	return &GormSink{db: db}, nil
+}
+
+// Write inserts the records in a single statement.
+func (s *GormSink) Write(ctx context.Context, records ...*rbac.AuditRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	rows := make([]*Record, 0, len(records))
+	for _, record := range records {
+		row := &Record{
+			Time:      record.Time,
+			Actor:     record.Actor,
+			Operation: record.Operation,
+			Reason:    record.Reason,
+			Before:    record.Before,
+			After:     record.After,
+		}
+		row.Sub, row.Grp = names(record)
+		rows = append(rows, row)
+	}
+
+	return s.db.WithContext(ctx).Create(&rows).Error
+}
+
+// Query returns the records which match the query, the latest first.
+func (s *GormSink) Query(ctx context.Context, query rbac.AuditQuery) ([]*rbac.AuditRecord, error) {
+	db := s.db.WithContext(ctx).Model(&Record{})
+	if !query.Since.IsZero() {
+		db = db.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		db = db.Where("created_at < ?", query.Until)
+	}
+	if query.Subject != "" {
+		db = db.Where("sub = ? OR grp = ?", query.Subject, query.Subject)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+
+	var rows []*Record
+	if err := db.Order("created_at desc, id desc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	records := make([]*rbac.AuditRecord, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, &rbac.AuditRecord{
+			Time:      row.Time,
+			Actor:     row.Actor,
+			Operation: row.Operation,
+			Reason:    row.Reason,
+			Before:    row.Before,
+			After:     row.After,
+		})
+	}
+	return records, nil
+}
+
+// names returns the subject and the group of the rule of the record. Only
+// g and g2 rules have a group.
+func names(record *rbac.AuditRecord) (sub, group string) {
+	rule := record.Rule()
+	if len(rule) > 1 {
+		sub = rule[1]
+	}
+	if len(rule) > 2 && rule[0] != "p" {
+		group = rule[2]
+	}
+	return
+}
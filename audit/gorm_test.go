@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/adapter"
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/vine/util/context/metadata"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestGormSink(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "audit.sqlite.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewGormSink(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := rbac.NewConfig(apt, rbac.WithAuditSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rbac.NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	ctx := metadata.Set(context.TODO(), rbac.ActorKey, "lack")
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "user", "read")))
+	assert.NoError(t, r.AddGroupPolicy(rbac.WithReason(ctx, "onboarding"), &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "reader"}))
+	assert.NoError(t, r.DelPolicy(ctx, api.NewPolicyWithString("reader", "user", "read")))
+
+	records, err := r.QueryAuditLog(ctx, rbac.AuditQuery{Since: start, Subject: "bob"})
+	if !assert.NoError(t, err) || !assert.Len(t, records, 1) {
+		return
+	}
+	assert.Equal(t, "lack", records[0].Actor)
+	assert.Equal(t, "AddGroupPolicy", records[0].Operation)
+	assert.Equal(t, "onboarding", records[0].Reason)
+	assert.Equal(t, []string{"g", "bob", "reader"}, records[0].After)
+
+	records, err = r.QueryAuditLog(ctx, rbac.AuditQuery{Subject: "reader"})
+	if !assert.NoError(t, err) || !assert.Len(t, records, 3) {
+		return
+	}
+	assert.Equal(t, "DelPolicy", records[0].Operation)
+	assert.Equal(t, []string{"p", "reader", "user", "read"}, records[0].Before)
+	assert.Empty(t, records[0].After)
+
+	records, err = r.QueryAuditLog(ctx, rbac.AuditQuery{Until: start})
+	assert.NoError(t, err)
+	assert.Len(t, records, 0)
+}
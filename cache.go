@@ -0,0 +1,204 @@
+package rbac
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+	casbinrbac "github.com/casbin/casbin/v2/rbac"
+)
+
+// DecisionCacheConfig configures the cache of the Enforce results.
+type DecisionCacheConfig struct {
+	// Size is the maximum number of cached results, 10000 by default. The
+	// least recently used result is evicted first.
+	Size int
+	// TTL is how long a result is cached, one minute by default.
+	TTL time.Duration
+}
+
+// WithDecisionCache caches the results of Enforce. The results affected by a
+// mutation are evicted when it is applied, and every result is evicted when
+// the policies are reloaded, so a cached result is never stale locally. The
+// mutations made by other replicas are only seen through a watcher, or once
+// the TTL expires.
+func WithDecisionCache(cfg DecisionCacheConfig) Option {
+	return func(c *Config) {
+		c.decisionCache = &cfg
+	}
+}
+
+// WithWatcher reloads the policies when the watcher reports a change made by
+// another replica, and notifies it of the mutations made through RBAC.
+func WithWatcher(watcher persist.Watcher) Option {
+	return func(c *Config) {
+		c.watcher = watcher
+	}
+}
+
+// cacheKey is the request of an Enforce call.
+type cacheKey struct {
+	sub, obj, act string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	allowed bool
+	rule    []string
+	expires time.Time
+}
+
+// decisionCache is a LRU cache of the Enforce results with a TTL.
+type decisionCache struct {
+	DecisionCacheConfig
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[cacheKey]*list.Element
+	// subjects indexes the cached requests by subject, for the invalidation
+	subjects map[string]map[cacheKey]struct{}
+}
+
+func newDecisionCache(cfg DecisionCacheConfig) *decisionCache {
+	if cfg.Size <= 0 {
+		cfg.Size = 10000
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Minute
+	}
+
+	return &decisionCache{
+		DecisionCacheConfig: cfg,
+		ll:                  list.New(),
+		items:               map[cacheKey]*list.Element{},
+		subjects:            map[string]map[cacheKey]struct{}{},
+	}
+}
+
+// get returns the cached result of the request, unless it has expired.
+func (c *decisionCache) get(key cacheKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(elem)
+	return entry, true
+}
+
+func (c *decisionCache) put(key cacheKey, allowed bool, rule []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, allowed: allowed, rule: rule, expires: time.Now().Add(c.TTL)}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(entry)
+	if c.subjects[key.sub] == nil {
+		c.subjects[key.sub] = map[cacheKey]struct{}{}
+	}
+	c.subjects[key.sub][key] = struct{}{}
+
+	if c.ll.Len() > c.Size {
+		c.remove(c.ll.Back())
+	}
+}
+
+// invalidate evicts the results of sub for the object and the action, or
+// every result of sub when obj is empty.
+func (c *decisionCache) invalidate(sub, obj, act string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.subjects[sub] {
+		if obj == "" || key.obj == obj && key.act == act {
+			c.remove(c.items[key])
+		}
+	}
+}
+
+// purge evicts every result.
+func (c *decisionCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = map[cacheKey]*list.Element{}
+	c.subjects = map[string]map[cacheKey]struct{}{}
+}
+
+func (c *decisionCache) remove(elem *list.Element) {
+	key := elem.Value.(*cacheEntry).key
+	c.ll.Remove(elem)
+	delete(c.items, key)
+	delete(c.subjects[key.sub], key)
+	if len(c.subjects[key.sub]) == 0 {
+		delete(c.subjects, key.sub)
+	}
+}
+
+// invalidateChanges evicts the results which the applied changes may have
+// changed. A p rule changes the results of its subject and of the subjects
+// which inherit it, for its object and action. A g or g2 rule may change
+// every result of its user, and of the users linked to it by the same ptype.
+func (r *rbac) invalidateChanges(changes []ruleChange) {
+	if r.cache == nil {
+		return
+	}
+
+	for _, c := range changes {
+		for _, rules := range [][][]string{c.removed, c.added} {
+			for _, rule := range rules {
+				if c.sec == "p" {
+					for _, sub := range append([]string{rule[0]}, inheritors(r.e, rule[0])...) {
+						r.cache.invalidate(sub, rule[1], rule[2])
+					}
+					continue
+				}
+
+				r.cache.invalidate(rule[0], "", "")
+				users := closure(r.e.GetNamedRoleManager(c.ptype), rule[0], casbinrbac.RoleManager.GetUsers)
+				for user := range users {
+					r.cache.invalidate(user, "", "")
+				}
+			}
+		}
+	}
+}
+
+// reload loads the policies of the adapter again, when the watcher reports
+// a change made by another replica, and sends the changes to the watchers of
+// Watch and the listeners of OnChange.
+func (r *rbac) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := r.e.GetModel().Copy()
+	if err := r.e.LoadPolicy(); err != nil {
+		return err
+	}
+	r.buildIndex()
+	if r.cache != nil {
+		r.cache.purge()
+	}
+	changes := diffRules(previous, modelRules(r.e.GetModel()), true)
+	revision := r.watchers.publish(context.Background(), ReloadOperation, changes)
+	if r.hooks != nil && revision > 0 {
+		r.hooks.notify(&ChangeEvent{Operation: ReloadOperation, Revision: revision, Epoch: r.watchers.epoch}, changes)
+	}
+	return nil
+}
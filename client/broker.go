@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/vine/core/broker"
+)
+
+// Subscribe applies the changes published by server.PublishChanges on the
+// topic of the broker to r, api.ChangeTopic by default. The rules which are
+// already added or removed are skipped, so r may be changed by other means
+// as well.
+//
+// A change delivered after a later one of the same publisher, which is told
+// by the epoch and the revision of the change, is dropped, so that it doesn't
+// undo the later one. The revisions of a publisher may have gaps, since the
+// changes it loads from the other replicas are not published.
+func Subscribe(b broker.Broker, topic string, r rbac.RBAC, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	if topic == "" {
+		topic = api.ChangeTopic
+	}
+
+	var mu sync.Mutex
+	// last is the last revision applied by epoch
+	last := map[string]int64{}
+
+	return b.Subscribe(topic, func(ev broker.Event) error {
+		change := &api.PolicyChange{}
+		if err := change.Unmarshal(ev.Message().Body); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if change.Revision > 0 {
+			if change.Revision <= last[change.Epoch] {
+				return nil
+			}
+			last[change.Epoch] = change.Revision
+		}
+		applyDiff(context.Background(), r, fromDiff(change.Diff))
+		return nil
+	}, opts...)
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/rbac/server"
+	"github.com/vine-io/vine/core/broker"
+	"github.com/vine-io/vine/core/broker/memory"
+)
+
+func newMemoryRBAC(t *testing.T, opts ...rbac.Option) rbac.RBAC {
+	cfg, err := rbac.NewConfig(newMemoryAdapter(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rbac.NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	return r
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.TODO()
+	b := memory.NewBroker()
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Disconnect()
+
+	published := newMemoryRBAC(t, server.PublishChanges(b, ""))
+	local := newMemoryRBAC(t)
+	sub, err := Subscribe(b, "", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	if err = published.AddPolicy(ctx, api.NewPolicyWithString("reader", "data1", "read")); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*api.Subject{
+		{Ptype: api.PType_ROLE, User: "alice", Group: "reader"},
+		{Ptype: api.PType_GROUP, User: "alice", Group: "reader"},
+	} {
+		if err = published.AddGroupPolicy(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = published.RenameSubject(ctx, "alice", "bob", false); err != nil {
+		t.Fatal(err)
+	}
+
+	enforce := func(sub string) bool {
+		ok, err := local.Enforce(ctx, api.NewPolicyWithString(sub, "data1", "read"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !enforce("bob") {
+		if time.Now().After(deadline) {
+			t.Fatal("changes not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if enforce("alice") {
+		t.Fatal("Enforce() allowed a renamed subject")
+	}
+}
+
+func TestSubscribeOrder(t *testing.T) {
+	ctx := context.TODO()
+	b := memory.NewBroker()
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Disconnect()
+
+	local := newMemoryRBAC(t)
+	sub, err := Subscribe(b, "", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	publish := func(epoch string, revision int64, diff *api.Diff) {
+		change := &api.PolicyChange{Epoch: epoch, Revision: revision, Diff: diff}
+		body, err := change.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = b.Publish(ctx, api.ChangeTopic, &broker.Message{Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy := api.NewPolicyWithString("alice", "data1", "read")
+
+	// the removal of revision 2 is delivered before the addition of revision
+	// 1, which is dropped
+	publish("a", 2, &api.Diff{RemovedPolicies: []*api.Policy{policy}})
+	publish("a", 1, &api.Diff{AddedPolicies: []*api.Policy{policy}})
+	if ok, _ := local.Enforce(ctx, policy); ok {
+		t.Fatal("Subscribe() applied a change delivered out of order")
+	}
+
+	// the revisions of another publisher are ordered on their own
+	publish("b", 1, &api.Diff{AddedPolicies: []*api.Policy{policy}})
+	if ok, _ := local.Enforce(ctx, policy); !ok {
+		t.Fatal("Subscribe() dropped the change of another publisher")
+	}
+}
@@ -0,0 +1,827 @@
+// Package client implements rbac.RBAC with the rbac service. Enforce is
+// served by a local snapshot of the rules, which is kept up to date by the
+// WatchPolicies stream, the other methods call the service.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/api"
+	vclient "github.com/vine-io/vine/core/client"
+	vapi "github.com/vine-io/vine/lib/api"
+	verrs "github.com/vine-io/vine/lib/errors"
+)
+
+// ErrNoSnapshot means the snapshot of the rules hasn't been loaded yet.
+var ErrNoSnapshot = errors.New("policy snapshot not loaded")
+
+// FailMode decides Enforce when the snapshot is stale and the service is
+// unreachable.
+type FailMode int
+
+const (
+	// FailClosed denies the requests.
+	FailClosed FailMode = iota
+	// FailOpen allows the requests.
+	FailOpen
+)
+
+// Options configures the Client.
+type Options struct {
+	// RetryInterval is how long the Client waits before it watches the
+	// service again when the stream failed, 5 seconds by default.
+	RetryInterval time.Duration
+	// MaxStaleness is how long the snapshot is used after the stream
+	// failed, then Enforce calls the service instead. 30 seconds by default.
+	MaxStaleness time.Duration
+	FailMode     FailMode
+	// CallOptions are passed to every call of the service.
+	CallOptions []vclient.CallOption
+}
+
+// Option sets an optional field of Options.
+type Option func(*Options)
+
+// WithRetryInterval sets how long the Client waits before it watches the
+// service again.
+func WithRetryInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.RetryInterval = d
+	}
+}
+
+// WithMaxStaleness sets how long the snapshot is used after the stream
+// failed.
+func WithMaxStaleness(d time.Duration) Option {
+	return func(o *Options) {
+		o.MaxStaleness = d
+	}
+}
+
+// WithFailMode sets how Enforce decides when the service is unreachable.
+func WithFailMode(mode FailMode) Option {
+	return func(o *Options) {
+		o.FailMode = mode
+	}
+}
+
+// WithCallOptions sets the options of every call of the service.
+func WithCallOptions(opts ...vclient.CallOption) Option {
+	return func(o *Options) {
+		o.CallOptions = append(o.CallOptions, opts...)
+	}
+}
+
+// Client implements rbac.RBAC with the rbac service. Enforce,
+// GetImplicitPermissions and Plan are served by a snapshot of the rules,
+// which is loaded by ExportPolicies and then updated by the events of
+// WatchPolicies. The mutations made through the Client are applied to the
+// snapshot as well, so that it reads its own writes.
+type Client struct {
+	opts Options
+	svc  api.RBACService
+
+	mu       sync.RWMutex
+	snapshot rbac.RBAC
+	// revision is the last revision applied to the snapshot, numbered in
+	// the epoch of the service
+	revision int64
+	epoch    string
+	// watching is set while the stream is up, otherwise the snapshot is
+	// fresh until MaxStaleness after updated
+	watching bool
+	updated  time.Time
+
+	exit chan struct{}
+	done chan struct{}
+}
+
+var _ rbac.RBAC = (*Client)(nil)
+
+// New returns a Client of the rbac service registered as name.
+func New(name string, c vclient.Client, opts ...Option) *Client {
+	return NewWithService(api.NewRBACService(name, c), opts...)
+}
+
+// NewWithService returns a Client of the service. The snapshot is loaded in
+// the background, Enforce calls the service until it is.
+func NewWithService(svc api.RBACService, opts ...Option) *Client {
+	options := Options{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = 5 * time.Second
+	}
+	if options.MaxStaleness <= 0 {
+		options.MaxStaleness = 30 * time.Second
+	}
+
+	c := &Client{
+		opts: options,
+		svc:  svc,
+		exit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go c.run()
+
+	return c
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c.exit
+		cancel()
+	}()
+
+	for {
+		err := c.watch(ctx)
+
+		c.mu.Lock()
+		if c.watching {
+			c.watching, c.updated = false, time.Now()
+		}
+		if errors.Is(err, rbac.ErrRevisionCompacted) {
+			// the snapshot is loaded again
+			c.revision, c.epoch = 0, ""
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.exit:
+			return
+		case <-time.After(c.opts.RetryInterval):
+		}
+	}
+}
+
+// watch updates the snapshot with the events of the stream until it fails.
+// The snapshot is loaded once the stream is up, unless the stream resumes
+// after the revision of the snapshot in the same epoch.
+func (c *Client) watch(ctx context.Context) error {
+	c.mu.RLock()
+	epoch, revision := c.epoch, c.revision
+	c.mu.RUnlock()
+
+	stream, err := c.svc.WatchPolicies(ctx, &api.WatchPoliciesRequest{Revision: revision, Epoch: epoch}, c.opts.CallOptions...)
+	if err != nil {
+		return fromError(err)
+	}
+	defer stream.Close()
+
+	for first := true; ; first = false {
+		rsp, err := stream.Recv()
+		if err != nil {
+			return fromError(err)
+		}
+
+		// the first response holds the revision which the stream follows,
+		// the snapshot is loaded after it when the stream starts from now on,
+		// or when the service restarted in another epoch
+		if first && (revision == 0 || rsp.Epoch != epoch) {
+			if err = c.Refresh(ctx); err != nil {
+				return err
+			}
+		}
+		c.apply(ctx, rsp)
+	}
+}
+
+// apply applies the events of the response to the snapshot. The events may
+// already be in the snapshot, when they were made through the Client or
+// loaded by Refresh.
+func (c *Client) apply(ctx context.Context, rsp *api.WatchPoliciesResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ev := range rsp.Events {
+		applyEvent(ctx, c.snapshot, ev)
+	}
+	c.revision, c.epoch, c.watching = rsp.Revision, rsp.Epoch, true
+}
+
+// Refresh loads the snapshot from the service.
+func (c *Client) Refresh(ctx context.Context) error {
+	buf := bytes.NewBuffer(nil)
+	if err := c.Export(ctx, buf); err != nil {
+		return err
+	}
+
+	cfg, err := rbac.NewConfig(newMemoryAdapter())
+	if err != nil {
+		return err
+	}
+	snapshot, err := rbac.NewRBAC(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err = snapshot.Import(ctx, buf, api.ImportMode_REPLACE); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	old := c.snapshot
+	c.snapshot, c.updated = snapshot, time.Now()
+	c.mu.Unlock()
+
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
+
+// current returns the snapshot, and whether it is fresh.
+func (c *Client) current() (rbac.RBAC, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.snapshot, c.snapshot != nil && (c.watching || time.Since(c.updated) <= c.opts.MaxStaleness)
+}
+
+func (c *Client) Enforce(ctx context.Context, p *api.Policy) (bool, error) {
+	if snapshot, fresh := c.current(); fresh {
+		return snapshot.Enforce(ctx, p)
+	}
+
+	rsp, err := c.svc.Enforce(ctx, &api.EnforceRequest{Policy: p}, c.opts.CallOptions...)
+	if err != nil {
+		if unreachable(err) {
+			return c.opts.FailMode == FailOpen, nil
+		}
+		return false, fromError(err)
+	}
+	return rsp.Result, nil
+}
+
+// GetImplicitPermissions returns the permissions of sub in the snapshot,
+// even when it is stale.
+func (c *Client) GetImplicitPermissions(ctx context.Context, sub string) []*rbac.Permission {
+	snapshot, _ := c.current()
+	if snapshot == nil {
+		return []*rbac.Permission{}
+	}
+	return snapshot.GetImplicitPermissions(ctx, sub)
+}
+
+// Plan computes the impact of the changes on the snapshot, even when it is
+// stale.
+func (c *Client) Plan(ctx context.Context, changes []*rbac.Change) (*rbac.Impact, error) {
+	snapshot, _ := c.current()
+	if snapshot == nil {
+		return nil, ErrNoSnapshot
+	}
+	return snapshot.Plan(ctx, changes)
+}
+
+func (c *Client) GetAllPolicies(ctx context.Context) ([]*api.Policy, []*api.Subject) {
+	rsp, err := c.svc.GetAllPolicies(ctx, &api.GetAllPoliciesRequest{}, c.opts.CallOptions...)
+	if err != nil {
+		return []*api.Policy{}, []*api.Subject{}
+	}
+	return rsp.Policies, rsp.Subjects
+}
+
+func (c *Client) GetPolicies(ctx context.Context, sub string) []*api.Policy {
+	rsp, err := c.svc.GetPolicies(ctx, &api.GetPoliciesRequest{Sub: sub}, c.opts.CallOptions...)
+	if err != nil {
+		return []*api.Policy{}
+	}
+	return rsp.Policies
+}
+
+func (c *Client) AddPolicy(ctx context.Context, p *api.Policy) error {
+	_, err := c.svc.AddPolicy(ctx, &api.AddPolicyRequest{Policy: p, Reason: rbac.Reason(ctx)}, c.opts.CallOptions...)
+	return c.mutated(err, func(snapshot rbac.RBAC) error {
+		return snapshot.AddPolicy(ctx, p)
+	})
+}
+
+func (c *Client) DelPolicy(ctx context.Context, p *api.Policy) error {
+	_, err := c.svc.DelPolicy(ctx, &api.DelPolicyRequest{Policy: p, Reason: rbac.Reason(ctx)}, c.opts.CallOptions...)
+	return c.mutated(err, func(snapshot rbac.RBAC) error {
+		return snapshot.DelPolicy(ctx, p)
+	})
+}
+
+func (c *Client) GetGroupPolicies(ctx context.Context, p api.PType, sub string) []*api.Subject {
+	rsp, err := c.svc.GetGroupPolicies(ctx, &api.GetGroupPoliciesRequest{Ptype: p, Sub: sub}, c.opts.CallOptions...)
+	if err != nil {
+		return []*api.Subject{}
+	}
+	return rsp.Subjects
+}
+
+func (c *Client) AddGroupPolicy(ctx context.Context, subject *api.Subject) error {
+	_, err := c.svc.AddGroupPolicy(ctx, &api.AddGroupPolicyRequest{Subject: subject, Reason: rbac.Reason(ctx)}, c.opts.CallOptions...)
+	return c.mutated(err, func(snapshot rbac.RBAC) error {
+		return snapshot.AddGroupPolicy(ctx, subject)
+	})
+}
+
+func (c *Client) DelGroupPolicy(ctx context.Context, subject *api.Subject) error {
+	_, err := c.svc.DelGroupPolicy(ctx, &api.DelGroupPolicyRequest{Subject: subject, Reason: rbac.Reason(ctx)}, c.opts.CallOptions...)
+	return c.mutated(err, func(snapshot rbac.RBAC) error {
+		return snapshot.DelGroupPolicy(ctx, subject)
+	})
+}
+
+func (c *Client) DeleteSubject(ctx context.Context, sub string) (*rbac.Deleted, error) {
+	rsp, err := c.svc.DeleteSubject(ctx, &api.DeleteSubjectRequest{Sub: sub, Reason: rbac.Reason(ctx)}, c.opts.CallOptions...)
+	err = c.mutated(err, func(snapshot rbac.RBAC) error {
+		_, err := snapshot.DeleteSubject(ctx, sub)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rbac.Deleted{Policies: int(rsp.Policies), Roles: int(rsp.Roles), Groups: int(rsp.Groups)}, nil
+}
+
+func (c *Client) DeleteRole(ctx context.Context, role string) (*rbac.Deleted, error) {
+	rsp, err := c.svc.DeleteRole(ctx, &api.DeleteRoleRequest{Role: role, Reason: rbac.Reason(ctx)}, c.opts.CallOptions...)
+	err = c.mutated(err, func(snapshot rbac.RBAC) error {
+		_, err := snapshot.DeleteRole(ctx, role)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rbac.Deleted{Policies: int(rsp.Policies), Roles: int(rsp.Roles), Groups: int(rsp.Groups)}, nil
+}
+
+func (c *Client) RenameSubject(ctx context.Context, oldName, newName string, merge bool) error {
+	_, err := c.svc.RenameSubject(ctx, &api.RenameSubjectRequest{
+		OldName: oldName,
+		NewName: newName,
+		Merge:   merge,
+		Reason:  rbac.Reason(ctx),
+	}, c.opts.CallOptions...)
+	return c.mutated(err, func(snapshot rbac.RBAC) error {
+		return snapshot.RenameSubject(ctx, oldName, newName, merge)
+	})
+}
+
+func (c *Client) ListPolicies(ctx context.Context, filter rbac.PolicyFilter, pageSize int, pageToken string) ([]*api.Policy, string, error) {
+	rsp, err := c.svc.ListPolicies(ctx, &api.ListPoliciesRequest{
+		SubPrefix: filter.SubPrefix,
+		Obj:       filter.Obj,
+		Act:       filter.Act,
+		PageSize:  int32(pageSize),
+		PageToken: pageToken,
+	}, c.opts.CallOptions...)
+	if err != nil {
+		return nil, "", fromError(err)
+	}
+	return rsp.Policies, rsp.NextPageToken, nil
+}
+
+func (c *Client) ListSubjects(ctx context.Context, filter rbac.SubjectFilter, pageSize int, pageToken string) ([]*api.Subject, string, error) {
+	rsp, err := c.svc.ListSubjects(ctx, &api.ListSubjectsRequest{
+		Ptype:      filter.Ptype,
+		UserPrefix: filter.UserPrefix,
+		Group:      filter.Group,
+		PageSize:   int32(pageSize),
+		PageToken:  pageToken,
+	}, c.opts.CallOptions...)
+	if err != nil {
+		return nil, "", fromError(err)
+	}
+	return rsp.Subjects, rsp.NextPageToken, nil
+}
+
+func (c *Client) GetSubjectsForEndpoint(ctx context.Context, endpoint *vapi.Endpoint) (*rbac.EndpointSubjects, error) {
+	rsp, err := c.svc.GetSubjectsForEndpoint(ctx, &api.GetSubjectsForEndpointRequest{Endpoint: endpoint}, c.opts.CallOptions...)
+	if err != nil {
+		return nil, fromError(err)
+	}
+	return &rbac.EndpointSubjects{Direct: rsp.Direct, Inherited: rsp.Inherited, SuperUsers: rsp.SuperUsers}, nil
+}
+
+func (c *Client) ExportMatrix(ctx context.Context, w io.Writer, format api.MatrixFormat, scope rbac.MatrixScope) error {
+	stream, err := c.svc.ExportMatrix(ctx, &api.ExportMatrixRequest{
+		Format:    format,
+		Subjects:  scope.Subjects,
+		ObjPrefix: scope.ObjPrefix,
+	}, c.opts.CallOptions...)
+	if err != nil {
+		return fromError(err)
+	}
+	defer stream.Close()
+
+	for {
+		rsp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fromError(err)
+		}
+		if _, err = w.Write(rsp.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// importChunkSize is the size of the data sent by each message of Import.
+const importChunkSize = 32 * 1024
+
+func (c *Client) Import(ctx context.Context, reader io.Reader, mode api.ImportMode) (*rbac.Imported, error) {
+	stream, err := c.svc.ImportPolicies(ctx, c.opts.CallOptions...)
+	if err != nil {
+		return nil, fromError(err)
+	}
+
+	// the file is imported to the snapshot as well
+	file := bytes.NewBuffer(nil)
+	reader = io.TeeReader(reader, file)
+
+	// the first message carries the mode, even when the file is empty
+	req := &api.ImportPoliciesRequest{Mode: mode, Reason: rbac.Reason(ctx)}
+	buf := make([]byte, importChunkSize)
+	for first := true; ; first = false {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 || first {
+			req.Data = append([]byte{}, buf[:n]...)
+			if e := stream.Send(req); e != nil {
+				return nil, fromError(e)
+			}
+			req = &api.ImportPoliciesRequest{}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rsp, err := stream.CloseAndRecv()
+	err = c.mutated(err, func(snapshot rbac.RBAC) error {
+		_, err := snapshot.Import(ctx, file, mode)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rbac.Imported{Added: int(rsp.Added), Removed: int(rsp.Removed)}, nil
+}
+
+func (c *Client) Export(ctx context.Context, w io.Writer) error {
+	stream, err := c.svc.ExportPolicies(ctx, &api.ExportPoliciesRequest{}, c.opts.CallOptions...)
+	if err != nil {
+		return fromError(err)
+	}
+	defer stream.Close()
+
+	for {
+		rsp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fromError(err)
+		}
+		if _, err = w.Write(rsp.Data); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) Reconcile(ctx context.Context, doc *rbac.Document, dryRun bool) (*rbac.Diff, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("%w: missing document", rbac.ErrInvalidDocument)
+	}
+	// JSON is a subset of the YAML parsed by the service
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", rbac.ErrInvalidDocument, err)
+	}
+
+	rsp, err := c.svc.Reconcile(ctx, &api.ReconcileRequest{
+		Document: data,
+		DryRun:   dryRun,
+		Reason:   rbac.Reason(ctx),
+	}, c.opts.CallOptions...)
+	if dryRun {
+		err = fromError(err)
+	} else {
+		err = c.mutated(err, func(snapshot rbac.RBAC) error {
+			_, err := snapshot.Reconcile(ctx, doc, false)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parsePlan(rsp.Plan)
+}
+
+func (c *Client) ListVersions(ctx context.Context, pageSize int, pageToken string) ([]*rbac.Version, string, error) {
+	rsp, err := c.svc.ListVersions(ctx, &api.ListVersionsRequest{PageSize: int32(pageSize), PageToken: pageToken}, c.opts.CallOptions...)
+	if err != nil {
+		return nil, "", fromError(err)
+	}
+
+	versions := make([]*rbac.Version, 0, len(rsp.Versions))
+	for _, v := range rsp.Versions {
+		versions = append(versions, &rbac.Version{
+			ID:        v.Id,
+			CreatedAt: time.Unix(v.Timestamp, 0),
+			Diff:      fromDiff(v.Diff),
+		})
+	}
+	return versions, rsp.NextPageToken, nil
+}
+
+func (c *Client) GetPolicyAt(ctx context.Context, version int64) ([]*api.Policy, []*api.Subject, error) {
+	rsp, err := c.svc.GetPolicyAt(ctx, &api.GetPolicyAtRequest{Version: version}, c.opts.CallOptions...)
+	if err != nil {
+		return nil, nil, fromError(err)
+	}
+	return rsp.Policies, rsp.Subjects, nil
+}
+
+func (c *Client) DiffVersions(ctx context.Context, from, to int64) (*rbac.Diff, error) {
+	rsp, err := c.svc.DiffVersions(ctx, &api.DiffVersionsRequest{From: from, To: to}, c.opts.CallOptions...)
+	if err != nil {
+		return nil, fromError(err)
+	}
+	return fromDiff(rsp.Diff), nil
+}
+
+func (c *Client) Rollback(ctx context.Context, version int64) (*rbac.Diff, error) {
+	rsp, err := c.svc.Rollback(ctx, &api.RollbackRequest{Version: version, Reason: rbac.Reason(ctx)}, c.opts.CallOptions...)
+	err = c.mutated(err, func(snapshot rbac.RBAC) error {
+		applyDiff(ctx, snapshot, fromDiff(rsp.Diff))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromDiff(rsp.Diff), nil
+}
+
+func (c *Client) QueryAuditLog(ctx context.Context, query rbac.AuditQuery) ([]*rbac.AuditRecord, error) {
+	req := &api.QueryAuditLogRequest{Subject: query.Subject, Limit: int32(query.Limit)}
+	if !query.Since.IsZero() {
+		req.Since = query.Since.Unix()
+	}
+	if !query.Until.IsZero() {
+		req.Until = query.Until.Unix()
+	}
+
+	rsp, err := c.svc.QueryAuditLog(ctx, req, c.opts.CallOptions...)
+	if err != nil {
+		return nil, fromError(err)
+	}
+
+	records := make([]*rbac.AuditRecord, 0, len(rsp.Records))
+	for _, record := range rsp.Records {
+		records = append(records, &rbac.AuditRecord{
+			Time:      time.Unix(record.Timestamp, 0),
+			Actor:     record.Actor,
+			Operation: record.Operation,
+			Reason:    record.Reason,
+			Before:    record.Before,
+			After:     record.After,
+		})
+	}
+	return records, nil
+}
+
+// Watch returns the changes of the rules streamed by WatchPolicies, as
+// rbac.RBAC.Watch. The channel is closed when ctx is done or the stream
+// fails.
+func (c *Client) Watch(ctx context.Context, epoch string, revision int64) (<-chan *rbac.WatchEvent, error) {
+	stream, err := c.svc.WatchPolicies(ctx, &api.WatchPoliciesRequest{Revision: revision, Epoch: epoch}, c.opts.CallOptions...)
+	if err != nil {
+		return nil, fromError(err)
+	}
+
+	// the first response tells whether the service resumes after revision
+	rsp, err := stream.Recv()
+	if err != nil {
+		stream.Close()
+		return nil, fromError(err)
+	}
+
+	events := make(chan *rbac.WatchEvent, 1)
+	events <- &rbac.WatchEvent{Revision: rsp.Revision, Epoch: rsp.Epoch, Events: rsp.Events}
+	go func() {
+		defer close(events)
+		defer stream.Close()
+
+		for {
+			rsp, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case events <- &rbac.WatchEvent{Revision: rsp.Revision, Epoch: rsp.Epoch, Events: rsp.Events}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// Close stops watching the service.
+func (c *Client) Close() error {
+	select {
+	case <-c.exit:
+		return nil
+	default:
+		close(c.exit)
+	}
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshot != nil {
+		return c.snapshot.Close()
+	}
+	return nil
+}
+
+// mutated converts the error of a mutation, and applies the mutation to the
+// snapshot when it succeeded. The snapshot may already have it from the
+// stream, so the error of apply is ignored.
+func (c *Client) mutated(err error, apply func(snapshot rbac.RBAC) error) error {
+	if err != nil {
+		return fromError(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshot != nil {
+		_ = apply(c.snapshot)
+	}
+	return nil
+}
+
+// applyEvent applies the event to the snapshot. The rules which are already
+// added or removed are skipped.
+func applyEvent(ctx context.Context, snapshot rbac.RBAC, ev *api.PolicyEvent) {
+	switch {
+	case ev.Policy != nil:
+		if ev.Type != api.EventType_ADDED {
+			policy := ev.Policy
+			if ev.Type == api.EventType_UPDATED {
+				policy = ev.PreviousPolicy
+			}
+			_ = snapshot.DelPolicy(ctx, policy)
+		}
+		if ev.Type != api.EventType_REMOVED {
+			_ = snapshot.AddPolicy(ctx, ev.Policy)
+		}
+	case ev.Subject != nil:
+		if ev.Type != api.EventType_ADDED {
+			subject := ev.Subject
+			if ev.Type == api.EventType_UPDATED {
+				subject = ev.PreviousSubject
+			}
+			_ = snapshot.DelGroupPolicy(ctx, subject)
+		}
+		if ev.Type != api.EventType_REMOVED {
+			_ = snapshot.AddGroupPolicy(ctx, ev.Subject)
+		}
+	}
+}
+
+// applyDiff applies the diff to the snapshot.
+func applyDiff(ctx context.Context, snapshot rbac.RBAC, d *rbac.Diff) {
+	for _, p := range d.RemovedPolicies {
+		applyEvent(ctx, snapshot, &api.PolicyEvent{Type: api.EventType_REMOVED, Policy: p})
+	}
+	for _, s := range d.RemovedSubjects {
+		applyEvent(ctx, snapshot, &api.PolicyEvent{Type: api.EventType_REMOVED, Subject: s})
+	}
+	for _, p := range d.AddedPolicies {
+		applyEvent(ctx, snapshot, &api.PolicyEvent{Type: api.EventType_ADDED, Policy: p})
+	}
+	for _, s := range d.AddedSubjects {
+		applyEvent(ctx, snapshot, &api.PolicyEvent{Type: api.EventType_ADDED, Subject: s})
+	}
+}
+
+// sentinels are the errors of rbac which are recognized in the errors of the
+// service.
+var sentinels = []error{
+	rbac.ErrAlreadyExists,
+	rbac.ErrNotFound,
+	rbac.ErrCasbin,
+	rbac.ErrInvalidPageToken,
+	rbac.ErrInvalidPolicyFile,
+	rbac.ErrInvalidDocument,
+	rbac.ErrVersionNotFound,
+	rbac.ErrAuditDisabled,
+	rbac.ErrRevisionCompacted,
+}
+
+// fromError converts the error of the service, so that errors.Is matches
+// the errors of rbac.
+func fromError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	detail := parseError(err).Detail
+	for _, sentinel := range sentinels {
+		if detail == sentinel.Error() {
+			return sentinel
+		}
+		if strings.HasPrefix(detail, sentinel.Error()+": ") {
+			return fmt.Errorf("%w%s", sentinel, strings.TrimPrefix(detail, sentinel.Error()))
+		}
+	}
+	return err
+}
+
+// parseError returns the error of vine. The errors of the streams are gRPC
+// statuses whose message is the error of vine.
+func parseError(err error) *verrs.Error {
+	e := verrs.FromErr(err)
+	if inner := verrs.Parse(e.Detail); inner.Code != 0 {
+		return inner
+	}
+	return e
+}
+
+// unreachable reports whether the error means the service couldn't be
+// reached, rather than the service rejecting the request.
+func unreachable(err error) bool {
+	e := parseError(err)
+	switch e.Code {
+	case verrs.StatusTimeout, verrs.StatusBadGateway, verrs.StatusServiceUnavailable, verrs.StatusGatewayTimeout:
+		return true
+	}
+	return e.Id == "go.vine.client"
+}
+
+func fromDiff(d *api.Diff) *rbac.Diff {
+	if d == nil {
+		return &rbac.Diff{}
+	}
+	return &rbac.Diff{
+		AddedPolicies:   d.AddedPolicies,
+		RemovedPolicies: d.RemovedPolicies,
+		AddedSubjects:   d.AddedSubjects,
+		RemovedSubjects: d.RemovedSubjects,
+	}
+}
+
+// parsePlan parses the lines of Diff.String, such as "+ p, alice, data1, read".
+func parsePlan(plan string) (*rbac.Diff, error) {
+	d := &rbac.Diff{}
+	for _, line := range strings.Split(plan, "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		added := line[0] == '+'
+
+		cr := csv.NewReader(strings.NewReader(line[2:]))
+		cr.TrimLeadingSpace = true
+		record, err := cr.Read()
+		if err != nil || len(record) < 3 {
+			return nil, fmt.Errorf("invalid plan line %q", line)
+		}
+
+		switch ptype := api.ParsePtype(record[0]); ptype {
+		case api.PType_POLICY:
+			if len(record) != 4 {
+				return nil, fmt.Errorf("invalid plan line %q", line)
+			}
+			p := api.NewPolicyWithString(record[1], record[2], record[3])
+			if added {
+				d.AddedPolicies = append(d.AddedPolicies, p)
+			} else {
+				d.RemovedPolicies = append(d.RemovedPolicies, p)
+			}
+		case api.PType_ROLE, api.PType_GROUP:
+			s := &api.Subject{Ptype: ptype, User: record[1], Group: record[2]}
+			if added {
+				d.AddedSubjects = append(d.AddedSubjects, s)
+			} else {
+				d.RemovedSubjects = append(d.RemovedSubjects, s)
+			}
+		default:
+			return nil, fmt.Errorf("invalid plan line %q", line)
+		}
+	}
+	return d, nil
+}
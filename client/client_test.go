@@ -0,0 +1,326 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/adapter"
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/rbac/server"
+	"github.com/vine-io/vine"
+	vclient "github.com/vine-io/vine/core/client"
+	"github.com/vine-io/vine/core/client/grpc"
+	gserver "github.com/vine-io/vine/core/server/grpc"
+	vapi "github.com/vine-io/vine/lib/api"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const name = "rbac"
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// newTestServer starts the rbac service, and returns its address.
+func newTestServer(t *testing.T) string {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "client.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := freeAddr(t)
+	s := vine.NewService(vine.Server(gserver.NewServer()), vine.Name(name), vine.Address(addr))
+	_ = s.Init()
+
+	srv, err := server.NewRBACServerWithApt(s, apt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = api.RegisterRBACServiceHandler(s.Server(), srv); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Server().Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	return addr
+}
+
+func newTestClient(t *testing.T, addr string, opts ...Option) *Client {
+	opts = append([]Option{WithCallOptions(vclient.WithAddress(addr))}, opts...)
+	c := New(name, grpc.NewClient(), opts...)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// waitFresh waits for the stream to be up.
+func waitFresh(t *testing.T, c *Client) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, fresh := c.current(); fresh {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("snapshot not loaded")
+}
+
+// unwatched is a service whose stream always fails.
+type unwatched struct {
+	api.RBACService
+}
+
+func (s *unwatched) WatchPolicies(ctx context.Context, in *api.WatchPoliciesRequest, opts ...vclient.CallOption) (api.RBACService_WatchPoliciesService, error) {
+	return nil, errors.New("unavailable")
+}
+
+// restarted is a service which streams the changes from now on in another
+// epoch, whatever the request.
+type restarted struct {
+	api.RBACService
+}
+
+func (s *restarted) WatchPolicies(ctx context.Context, in *api.WatchPoliciesRequest, opts ...vclient.CallOption) (api.RBACService_WatchPoliciesService, error) {
+	stream, err := s.RBACService.WatchPolicies(ctx, &api.WatchPoliciesRequest{}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &restartedStream{stream}, nil
+}
+
+type restartedStream struct {
+	api.RBACService_WatchPoliciesService
+}
+
+func (s *restartedStream) Recv() (*api.WatchPoliciesResponse, error) {
+	rsp, err := s.RBACService_WatchPoliciesService.Recv()
+	if err == nil {
+		rsp.Epoch = "restarted"
+	}
+	return rsp, err
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestClient(t, newTestServer(t))
+	waitFresh(t, c)
+
+	if err := c.AddPolicy(ctx, api.NewPolicyWithString("admin", "data1", "read")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddPolicy(ctx, api.NewPolicyWithString("admin", "data1", "read")); !errors.Is(err, rbac.ErrAlreadyExists) {
+		t.Fatalf("AddPolicy() = %v, want ErrAlreadyExists", err)
+	}
+	for _, s := range []*api.Subject{
+		{Ptype: api.PType_ROLE, User: "alice", Group: "admin"},
+		{Ptype: api.PType_GROUP, User: "alice", Group: "admin"},
+	} {
+		if err := c.AddGroupPolicy(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.DelGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "admin"}); !errors.Is(err, rbac.ErrNotFound) {
+		t.Fatalf("DelGroupPolicy() = %v, want ErrNotFound", err)
+	}
+
+	if n := len(c.GetPolicies(ctx, "admin")); n != 1 {
+		t.Fatalf("GetPolicies() returned %d policies, want 1", n)
+	}
+	if n := len(c.GetGroupPolicies(ctx, api.PType_ROLE, "alice")); n != 1 {
+		t.Fatalf("GetGroupPolicies() returned %d subjects, want 1", n)
+	}
+
+	// the mutations made through the client are applied to the snapshot
+	for _, tt := range []struct {
+		sub, obj string
+		want     bool
+	}{
+		{"alice", "data1", true},
+		{"admin", "data1", true},
+		{"bob", "data1", false},
+		{"alice", "data2", false},
+	} {
+		ok, err := c.Enforce(ctx, api.NewPolicyWithString(tt.sub, tt.obj, "read"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want {
+			t.Fatalf("Enforce(%s, %s) = %v, want %v", tt.sub, tt.obj, ok, tt.want)
+		}
+	}
+	if n := len(c.GetImplicitPermissions(ctx, "alice")); n != 1 {
+		t.Fatalf("GetImplicitPermissions() returned %d permissions, want 1", n)
+	}
+
+	d, err := c.Reconcile(ctx, &rbac.Document{
+		Roles: []*rbac.RoleDocument{{Name: "admin", Endpoints: []*vapi.Endpoint{
+			{Name: "data1", Method: []string{"read"}},
+			{Name: "data2", Method: []string{"read"}},
+		}}},
+		Memberships: []*rbac.Membership{{User: "alice", Roles: []string{"admin"}, Groups: []string{"admin"}}},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.AddedPolicies) != 1 || d.AddedPolicies[0].Endpoint.Name != "data2" {
+		t.Fatalf("Reconcile() = %v, want data2 added", d)
+	}
+
+	if err = c.DelPolicy(ctx, api.NewPolicyWithString("admin", "data1", "read")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.Enforce(ctx, api.NewPolicyWithString("alice", "data1", "read")); ok {
+		t.Fatal("Enforce() allowed a deleted policy")
+	}
+
+	if _, _, err = c.GetPolicyAt(ctx, 1000); !errors.Is(err, rbac.ErrVersionNotFound) {
+		t.Fatalf("GetPolicyAt() = %v, want ErrVersionNotFound", err)
+	}
+}
+
+func TestClientWatch(t *testing.T) {
+	ctx := context.TODO()
+	addr := newTestServer(t)
+
+	admin := newTestClient(t, addr)
+	if err := admin.AddPolicy(ctx, api.NewPolicyWithString("alice", "data1", "read")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestClient(t, addr)
+	waitFresh(t, c)
+	events, err := c.Watch(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := <-events
+	epoch, revision := first.Epoch, first.Revision
+
+	// the changes made by another client are streamed to the snapshot
+	if err = admin.RenameSubject(ctx, "alice", "bob", false); err != nil {
+		t.Fatal(err)
+	}
+	ev := <-events
+	if ev.Revision != revision+1 || ev.Epoch != epoch || len(ev.Events) != 1 || ev.Events[0].Type != api.EventType_UPDATED {
+		t.Fatalf("Watch() = %v, want an update", ev)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if permissions := c.GetImplicitPermissions(ctx, "bob"); len(permissions) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot not updated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ok, _ := c.Enforce(ctx, api.NewPolicyWithString("alice", "data1", "read")); ok {
+		t.Fatal("Enforce() allowed a renamed subject")
+	}
+
+	if _, err = c.Watch(ctx, epoch, revision+100); !errors.Is(err, rbac.ErrRevisionCompacted) {
+		t.Fatalf("Watch() = %v, want ErrRevisionCompacted", err)
+	}
+	if _, err = c.Watch(ctx, "", revision); !errors.Is(err, rbac.ErrRevisionCompacted) {
+		t.Fatalf("Watch() of another epoch = %v, want ErrRevisionCompacted", err)
+	}
+}
+
+func TestClientEpoch(t *testing.T) {
+	ctx := context.TODO()
+	addr := newTestServer(t)
+
+	admin := newTestClient(t, addr)
+	if err := admin.AddPolicy(ctx, api.NewPolicyWithString("alice", "data1", "read")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot follows a revision of a previous epoch, it is loaded
+	// again once the stream is up in another one
+	c := &Client{
+		opts:     Options{CallOptions: []vclient.CallOption{vclient.WithAddress(addr)}},
+		svc:      &restarted{RBACService: api.NewRBACService(name, grpc.NewClient())},
+		snapshot: newMemoryRBAC(t),
+		revision: 1,
+		epoch:    "previous",
+	}
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() { _ = c.watch(watchCtx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot, _ := c.current()
+		if ok, _ := snapshot.Enforce(ctx, api.NewPolicyWithString("alice", "data1", "read")); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot not loaded again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientStale(t *testing.T) {
+	ctx := context.TODO()
+	addr := newTestServer(t)
+
+	admin := newTestClient(t, addr)
+	if err := admin.AddPolicy(ctx, api.NewPolicyWithString("alice", "data1", "read")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot is never loaded, so every Enforce calls the service
+	svc := &unwatched{RBACService: api.NewRBACService(name, grpc.NewClient())}
+	c := NewWithService(svc, WithCallOptions(vclient.WithAddress(addr)))
+	defer c.Close()
+
+	ok, err := c.Enforce(ctx, api.NewPolicyWithString("alice", "data1", "read"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("Enforce() denied a granted policy")
+	}
+}
+
+func TestClientFailMode(t *testing.T) {
+	ctx := context.TODO()
+	addr := freeAddr(t)
+
+	for _, tt := range []struct {
+		mode FailMode
+		want bool
+	}{
+		{FailClosed, false},
+		{FailOpen, true},
+	} {
+		t.Run(fmt.Sprint(tt.mode), func(t *testing.T) {
+			c := newTestClient(t, addr, WithFailMode(tt.mode), WithCallOptions(vclient.WithRetries(0)))
+			ok, err := c.Enforce(ctx, api.NewPolicyWithString("alice", "data1", "read"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.want {
+				t.Fatalf("Enforce() = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/vine-io/rbac/adapter"
+)
+
+// memoryAdapter keeps the rules of the snapshot in memory.
+type memoryAdapter struct {
+	rules map[string][]string
+}
+
+var (
+	_ persist.Adapter      = (*memoryAdapter)(nil)
+	_ adapter.VersionStore = (*memoryAdapter)(nil)
+)
+
+func newMemoryAdapter() *memoryAdapter {
+	return &memoryAdapter{rules: map[string][]string{}}
+}
+
+func ruleKey(ptype string, rule []string) string {
+	return ptype + "\x00" + strings.Join(rule, "\x00")
+}
+
+func (a *memoryAdapter) LoadPolicy(m model.Model) error {
+	keys := make([]string, 0, len(a.rules))
+	for key := range a.rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := persist.LoadPolicyArray(a.rules[key], m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *memoryAdapter) SavePolicy(m model.Model) error {
+	a.rules = map[string][]string{}
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range m[sec] {
+			for _, rule := range ast.Policy {
+				_ = a.AddPolicy(sec, ptype, rule)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *memoryAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	a.rules[ruleKey(ptype, rule)] = append([]string{ptype}, rule...)
+	return nil
+}
+
+func (a *memoryAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	delete(a.rules, ruleKey(ptype, rule))
+	return nil
+}
+
+func (a *memoryAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	for key, line := range a.rules {
+		if line[0] != ptype {
+			continue
+		}
+		matched := true
+		for i, value := range fieldValues {
+			if value != "" && (fieldIndex+i+1 >= len(line) || line[fieldIndex+i+1] != value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			delete(a.rules, key)
+		}
+	}
+	return nil
+}
+
+// AddVersion discards the versions of the snapshot, they are read from the
+// service.
+func (a *memoryAdapter) AddVersion(v *adapter.Version) error {
+	return nil
+}
+
+func (a *memoryAdapter) ListVersions(after int64, limit int) ([]*adapter.Version, error) {
+	return []*adapter.Version{}, nil
+}
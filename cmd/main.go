@@ -3,8 +3,10 @@ package main
 import (
 	"log"
 
+	"github.com/vine-io/rbac"
 	"github.com/vine-io/rbac/adapter"
 	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/rbac/audit"
 	"github.com/vine-io/rbac/server"
 	"github.com/vine-io/vine"
 	"gorm.io/driver/sqlite"
@@ -26,17 +28,30 @@ func main() {
 		log.Fatal(err)
 	}
 
+	sink, err := audit.NewGormSink(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	s := vine.NewService()
 	if err = s.Init(); err != nil {
 		log.Fatal(err)
 	}
 
-	rbac, err := server.NewRBACServerWithApt(s, apt)
+	handler, err := server.NewRBACServerWithApt(s, apt, rbac.WithAuditSink(sink), rbac.WithGroupingMigration(), server.PublishChanges(s.Options().Broker, api.ChangeTopic))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := server.NewGormWebhookStore(db)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if _, err = handler.EnableWebhooks(server.WebhookConfig{Store: store}); err != nil {
+		log.Fatal(err)
+	}
 
-	if err = api.RegisterRBACServiceHandler(s.Server(), rbac); err != nil {
+	if err = api.RegisterRBACServiceHandler(s.Server(), handler); err != nil {
 		log.Fatal(err)
 	}
 
@@ -0,0 +1,148 @@
+package rbac
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vine-io/rbac/api"
+)
+
+// Imported counts the rules changed by Import in the storage. When the
+// adapter implements adapter.CountingAdapter, the rules which another replica
+// already added or removed are not counted.
+type Imported struct {
+	// Added is the number of rules added.
+	Added int
+	// Removed is the number of rules removed by api.ImportMode_REPLACE.
+	Removed int
+}
+
+// csvSections lists the sections of the policy files, in the order of Export.
+var csvSections = []struct {
+	sec    string
+	ptype  string
+	fields int
+}{
+	{"p", "p", 3},
+	{"g", "g", 2},
+	{"g", "g2", 2},
+}
+
+// Import loads the rules of a policy file in the casbin CSV format, such as
+// "p, alice, data1, read" or "g, alice, admin". With api.ImportMode_MERGE the
+// rules are added to the existing ones, with api.ImportMode_REPLACE they
+// replace every existing rule. The whole file is validated before anything
+// is changed, and either every change is applied or none of them.
+func (r *rbac) Import(ctx context.Context, reader io.Reader, mode api.ImportMode) (*Imported, error) {
+	switch mode {
+	case api.ImportMode_MERGE, api.ImportMode_REPLACE:
+	default:
+		return nil, fmt.Errorf("invalid import mode")
+	}
+
+	rules, err := readPolicyFile(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes := diffRules(r.e.GetModel(), rules, mode == api.ImportMode_REPLACE)
+	imported, err := r.applyChanges(changes)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.commit(ctx, "Import", changes...); err != nil {
+		return nil, err
+	}
+
+	return imported, nil
+}
+
+// readPolicyFile reads and validates the rules of a policy file, grouped by
+// ptype. Duplicated rules are skipped.
+func readPolicyFile(reader io.Reader) (map[string][][]string, error) {
+	cr := csv.NewReader(reader)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	fields := map[string]int{}
+	for _, section := range csvSections {
+		fields[section.ptype] = section.fields
+	}
+
+	rules := map[string][][]string{}
+	seen := map[string]struct{}{}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPolicyFile, err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		ptype, rule := record[0], record[1:]
+		n, ok := fields[ptype]
+		if !ok {
+			return nil, fmt.Errorf("%w: line %d: unknown ptype %q", ErrInvalidPolicyFile, line, ptype)
+		}
+		if len(rule) != n {
+			return nil, fmt.Errorf("%w: line %d: %s rule needs %d fields", ErrInvalidPolicyFile, line, ptype, n)
+		}
+		for _, field := range rule {
+			if field == "" {
+				return nil, fmt.Errorf("%w: line %d: empty field", ErrInvalidPolicyFile, line)
+			}
+		}
+
+		key := ptype + "," + strings.Join(rule, ",")
+		if _, ok = seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		rules[ptype] = append(rules[ptype], rule)
+	}
+
+	return rules, nil
+}
+
+// Export writes every rule to w in the casbin CSV format, which can be loaded
+// again by Import or by casbin's file adapter.
+func (r *rbac) Export(ctx context.Context, w io.Writer) error {
+	// the rules are copied, so that a slow writer doesn't hold the lock
+	r.mu.RLock()
+	rules := modelRules(r.e.GetModel())
+	r.mu.RUnlock()
+
+	for _, section := range csvSections {
+		for _, rule := range rules[section.ptype] {
+			fields := make([]string, 0, len(rule)+1)
+			fields = append(fields, section.ptype)
+			for _, field := range rule {
+				fields = append(fields, quoteField(field))
+			}
+			if _, err := io.WriteString(w, strings.Join(fields, ", ")+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// quoteField quotes the field when the CSV reader would split or trim it,
+// such as the actions of an endpoint with several methods.
+func quoteField(field string) string {
+	if !strings.ContainsAny(field, ",\"\r\n") && strings.TrimLeft(field, " \t") == field {
+		return field
+	}
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
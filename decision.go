@@ -0,0 +1,194 @@
+package rbac
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/vine-io/vine/lib/logger"
+)
+
+// Decision is the result of an Enforce call.
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Object  string    `json:"object"`
+	Action  string    `json:"action"`
+	Allowed bool      `json:"allowed"`
+	// Rule is the policy which allowed the request. It is empty when the
+	// request is denied or allowed because the subject is a super user.
+	Rule    []string      `json:"rule,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+// DecisionLogger stores the decisions of Enforce. When it implements
+// io.Closer, it is closed by RBAC.Close.
+type DecisionLogger interface {
+	// LogDecisions stores a batch of decisions.
+	LogDecisions(ctx context.Context, decisions []*Decision) error
+}
+
+// DecisionLogConfig configures the decision log.
+type DecisionLogConfig struct {
+	Logger DecisionLogger
+	// SampleRate is the fraction of the allowed decisions which are logged,
+	// from 0 to 1. The denied decisions are always logged.
+	SampleRate float64
+	// BatchSize is the maximum number of decisions passed to the logger at
+	// once, 100 by default.
+	BatchSize int
+	// FlushInterval is the maximum time a decision waits for its batch, one
+	// second by default.
+	FlushInterval time.Duration
+	// DenyTimeout is the maximum time a denied decision waits for room in
+	// the queue when the logger can't keep up, 100 milliseconds by default.
+	DenyTimeout time.Duration
+}
+
+// WithDecisionLog logs the decisions of Enforce in the background. When the
+// logger can't keep up, the sampled allowed decisions are dropped so that
+// Enforce doesn't wait for it, while a denied decision waits up to
+// DenyTimeout for room in the queue. The dropped decisions are counted and
+// reported in the log.
+func WithDecisionLog(cfg DecisionLogConfig) Option {
+	return func(c *Config) {
+		c.decisionLog = &cfg
+	}
+}
+
+// decisionLog batches the decisions for the logger.
+type decisionLog struct {
+	DecisionLogConfig
+
+	queue chan *Decision
+	// stop is closed by close, the queue is never closed so that log can't
+	// send on a closed channel
+	stop      chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+	dropped   int64
+	lastDrops int64
+}
+
+func newDecisionLog(cfg DecisionLogConfig) *decisionLog {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.DenyTimeout <= 0 {
+		cfg.DenyTimeout = 100 * time.Millisecond
+	}
+
+	l := &decisionLog{
+		DecisionLogConfig: cfg,
+		queue:             make(chan *Decision, cfg.BatchSize*16),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	go l.run()
+
+	return l
+}
+
+// log queues the decision when it is sampled. When the queue is full, an
+// allowed decision is dropped, and a denied one waits up to DenyTimeout
+// before it is dropped.
+func (l *decisionLog) log(d *Decision) {
+	if d.Allowed && (l.SampleRate <= 0 || l.SampleRate < 1 && rand.Float64() >= l.SampleRate) {
+		return
+	}
+
+	select {
+	case <-l.stop:
+		return
+	case l.queue <- d:
+		return
+	default:
+	}
+	if d.Allowed {
+		atomic.AddInt64(&l.dropped, 1)
+		return
+	}
+
+	timer := time.NewTimer(l.DenyTimeout)
+	defer timer.Stop()
+	select {
+	case <-l.stop:
+	case l.queue <- d:
+	case <-timer.C:
+		atomic.AddInt64(&l.dropped, 1)
+	}
+}
+
+func (l *decisionLog) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Decision, 0, l.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := l.Logger.LogDecisions(context.Background(), batch); err != nil {
+			log.Errorf("rbac: log %d decisions: %v", len(batch), err)
+		}
+		batch = make([]*Decision, 0, l.BatchSize)
+	}
+
+	for {
+		select {
+		case d := <-l.queue:
+			batch = append(batch, d)
+			if len(batch) == l.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			l.reportDrops()
+		case <-l.stop:
+			// flush the queued decisions
+		drain:
+			for {
+				select {
+				case d := <-l.queue:
+					batch = append(batch, d)
+					if len(batch) == l.BatchSize {
+						flush()
+					}
+				default:
+					break drain
+				}
+			}
+			flush()
+			l.reportDrops()
+			return
+		}
+	}
+}
+
+// reportDrops logs the decisions dropped since the last report.
+func (l *decisionLog) reportDrops() {
+	dropped := atomic.LoadInt64(&l.dropped)
+	if n := dropped - l.lastDrops; n > 0 {
+		log.Errorf("rbac: dropped %d decisions, the decision logger can't keep up", n)
+	}
+	l.lastDrops = dropped
+}
+
+// close flushes the queued decisions and closes the logger.
+func (l *decisionLog) close() error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+
+	if closer, ok := l.Logger.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package decision
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/adapter"
+	"github.com/vine-io/rbac/api"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newRBAC(t *testing.T, db *gorm.DB, logger rbac.DecisionLogger, rate float64) rbac.RBAC {
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := rbac.NewConfig(apt, rbac.WithDecisionLog(rbac.DecisionLogConfig{Logger: logger, SampleRate: rate}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rbac.NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "data1", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "bob", Group: "reader"}))
+	return r
+}
+
+func TestFileLogger(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "decision.sqlite.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "decisions.jsonl")
+	logger, err := NewFileLogger(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the allowed decisions are not sampled
+	r := newRBAC(t, db, logger, 0)
+	ctx := context.TODO()
+	ok, err := r.Enforce(ctx, api.NewPolicyWithString("bob", "data1", "read"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = r.Enforce(ctx, api.NewPolicyWithString("bob", "data1", "write"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, r.Close())
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	decisions := make([]*rbac.Decision, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		d := &rbac.Decision{}
+		if assert.NoError(t, json.Unmarshal(scanner.Bytes(), d)) {
+			decisions = append(decisions, d)
+		}
+	}
+	if !assert.Len(t, decisions, 1) {
+		return
+	}
+	assert.Equal(t, "bob", decisions[0].Subject)
+	assert.Equal(t, "write", decisions[0].Action)
+	assert.False(t, decisions[0].Allowed)
+}
+
+func TestGormLogger(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "decision.sqlite.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, err := NewGormLogger(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRBAC(t, db, logger, 1)
+	ctx := context.TODO()
+	_, err = r.Enforce(ctx, api.NewPolicyWithString("bob", "data1", "read"))
+	assert.NoError(t, err)
+	_, err = r.Enforce(ctx, api.NewPolicyWithString("root", "data2", "read"))
+	assert.NoError(t, err)
+	_, err = r.Enforce(ctx, api.NewPolicyWithString("alice", "data1", "read"))
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	var rows []*Record
+	if !assert.NoError(t, db.Order("id").Find(&rows).Error) || !assert.Len(t, rows, 3) {
+		return
+	}
+	assert.True(t, rows[0].Allowed)
+	assert.Equal(t, []string{"reader", "data1", "read"}, rows[0].Rule)
+	// the super users are allowed without a rule
+	assert.True(t, rows[1].Allowed)
+	assert.Empty(t, rows[1].Rule)
+	assert.False(t, rows[2].Allowed)
+	assert.Equal(t, "alice", rows[2].Sub)
+}
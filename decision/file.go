@@ -0,0 +1,56 @@
+package decision
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/vine-io/rbac"
+)
+
+// FileLogger appends the decisions to a file, one JSON object per line.
+type FileLogger struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+var _ rbac.DecisionLogger = (*FileLogger)(nil)
+
+// NewFileLogger opens the file for appending, it is created when missing.
+func NewFileLogger(name string) (*FileLogger, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileLogger{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// LogDecisions writes the batch to the file.
+func (l *FileLogger) LogDecisions(ctx context.Context, decisions []*rbac.Decision) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	enc := json.NewEncoder(l.w)
+	for _, d := range decisions {
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	return l.w.Flush()
+}
+
+// Close closes the file.
+func (l *FileLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.w.Flush(); err != nil {
+		_ = l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
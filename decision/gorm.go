@@ -0,0 +1,64 @@
+package decision
+
+import (
+	"context"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"gorm.io/gorm"
+)
+
+// Record is a row of the rbac_decision table.
+type Record struct {
+	ID        uint      `gorm:"column:id;primaryKey;autoIncrement"`
+	CreatedAt time.Time `gorm:"column:created_at;index"`
+	Sub       string    `gorm:"column:sub;size:100;index"`
+	Obj       string    `gorm:"column:obj;size:100"`
+	Act       string    `gorm:"column:act;size:100"`
+	Allowed   bool      `gorm:"column:allowed"`
+	Rule      []string  `gorm:"column:rule;type:text;serializer:json"`
+	// Latency is in nanoseconds.
+	Latency int64 `gorm:"column:latency"`
+}
+
+func (Record) TableName() string {
+	return "rbac_decision"
+}
+
+// GormLogger stores the decisions in the rbac_decision table.
+type GormLogger struct {
+	db *gorm.DB
+}
+
+var _ rbac.DecisionLogger = (*GormLogger)(nil)
+
+// NewGormLogger is the constructor for GormLogger.
+func NewGormLogger(db *gorm.DB) (*GormLogger, error) {
+	if err := db.AutoMigrate(&Record{}); err != nil {
+		return nil, err
+	}
+
+	return &GormLogger{db: db}, nil
+}
+
+// LogDecisions inserts the batch in a single statement.
+func (l *GormLogger) LogDecisions(ctx context.Context, decisions []*rbac.Decision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+
+	rows := make([]*Record, 0, len(decisions))
+	for _, d := range decisions {
+		rows = append(rows, &Record{
+			CreatedAt: d.Time,
+			Sub:       d.Subject,
+			Obj:       d.Object,
+			Act:       d.Action,
+			Allowed:   d.Allowed,
+			Rule:      d.Rule,
+			Latency:   int64(d.Latency),
+		})
+	}
+
+	return l.db.WithContext(ctx).Create(&rows).Error
+}
@@ -0,0 +1,119 @@
+package rbac
+
+import (
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/vine-io/rbac/api"
+)
+
+// Diff holds the rules which are added and removed by a change.
+type Diff struct {
+	AddedPolicies   []*api.Policy
+	RemovedPolicies []*api.Policy
+	AddedSubjects   []*api.Subject
+	RemovedSubjects []*api.Subject
+}
+
+// Empty reports whether the diff changes nothing.
+func (d *Diff) Empty() bool {
+	return len(d.AddedPolicies)+len(d.RemovedPolicies)+len(d.AddedSubjects)+len(d.RemovedSubjects) == 0
+}
+
+// String returns the plan of the diff, one rule per line in the casbin CSV
+// format prefixed by "-" when it is removed and "+" when it is added.
+func (d *Diff) String() string {
+	var sb strings.Builder
+	for _, p := range d.RemovedPolicies {
+		sb.WriteString("- " + policyLine(p) + "\n")
+	}
+	for _, s := range d.RemovedSubjects {
+		sb.WriteString("- " + subjectLine(s) + "\n")
+	}
+	for _, p := range d.AddedPolicies {
+		sb.WriteString("+ " + policyLine(p) + "\n")
+	}
+	for _, s := range d.AddedSubjects {
+		sb.WriteString("+ " + subjectLine(s) + "\n")
+	}
+	return sb.String()
+}
+
+// newDiff describes the changes.
+func newDiff(changes []ruleChange) *Diff {
+	d := &Diff{
+		AddedPolicies:   make([]*api.Policy, 0),
+		RemovedPolicies: make([]*api.Policy, 0),
+		AddedSubjects:   make([]*api.Subject, 0),
+		RemovedSubjects: make([]*api.Subject, 0),
+	}
+
+	for _, c := range changes {
+		if c.sec == "p" {
+			for _, rule := range c.added {
+				d.AddedPolicies = append(d.AddedPolicies, newPolicy(rule[0], rule[1], rule[2:]...))
+			}
+			for _, rule := range c.removed {
+				d.RemovedPolicies = append(d.RemovedPolicies, newPolicy(rule[0], rule[1], rule[2:]...))
+			}
+			continue
+		}
+
+		ptype := api.ParsePtype(c.ptype)
+		for _, rule := range c.added {
+			d.AddedSubjects = append(d.AddedSubjects, &api.Subject{Ptype: ptype, User: rule[0], Group: rule[1]})
+		}
+		for _, rule := range c.removed {
+			d.RemovedSubjects = append(d.RemovedSubjects, &api.Subject{Ptype: ptype, User: rule[0], Group: rule[1]})
+		}
+	}
+
+	return d
+}
+
+// diffRules returns the changes which bring the rules of m to the wanted
+// rules, grouped by ptype. Unless replace is set, the rules of m which are
+// not wanted are kept.
+func diffRules(m model.Model, wanted map[string][][]string, replace bool) []ruleChange {
+	changes := make([]ruleChange, 0)
+	for _, section := range csvSections {
+		change := ruleChange{sec: section.sec, ptype: section.ptype}
+
+		keys := map[string]struct{}{}
+		for _, rule := range wanted[section.ptype] {
+			key := strings.Join(rule, ",")
+			if _, ok := keys[key]; ok {
+				continue
+			}
+			keys[key] = struct{}{}
+			if !m.HasPolicy(section.sec, section.ptype, rule) {
+				change.added = append(change.added, rule)
+			}
+		}
+
+		if replace {
+			for _, rule := range m.GetPolicy(section.sec, section.ptype) {
+				if _, ok := keys[strings.Join(rule, ",")]; !ok {
+					change.removed = append(change.removed, rule)
+				}
+			}
+		}
+
+		if len(change.added)+len(change.removed) > 0 {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// policyLine formats the policy as a p rule of a policy file.
+func policyLine(p *api.Policy) string {
+	obj, act := parseEndpoint(p.Endpoint)
+	return strings.Join([]string{api.PType_POLICY.Name(), quoteField(p.Sub), quoteField(obj), quoteField(act)}, ", ")
+}
+
+// subjectLine formats the subject as a g or g2 rule of a policy file.
+func subjectLine(s *api.Subject) string {
+	return strings.Join([]string{s.Ptype.Name(), quoteField(s.User), quoteField(s.Group)}, ", ")
+}
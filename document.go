@@ -0,0 +1,159 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/vine-io/rbac/api"
+	vapi "github.com/vine-io/vine/lib/api"
+	"gopkg.in/yaml.v3"
+)
+
+// Document is the desired state of the rules, which is usually kept in git
+// as a YAML or JSON file:
+//
+//	roles:
+//	  - name: reader
+//	    endpoints:
+//	      - entity: user
+//	        method: [read]
+//	memberships:
+//	  - user: lack
+//	    roles: [reader]
+//	    groups: [reader]
+//	superUsers: [admin]
+type Document struct {
+	Roles       []*RoleDocument `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Memberships []*Membership   `json:"memberships,omitempty" yaml:"memberships,omitempty"`
+	// SuperUsers can access every endpoint. They are defined by the matcher
+	// of the model, so the document may only list the ones it allows.
+	SuperUsers []string `json:"superUsers,omitempty" yaml:"superUsers,omitempty"`
+}
+
+// RoleDocument holds the endpoints granted to a role.
+type RoleDocument struct {
+	Name      string           `json:"name" yaml:"name"`
+	Endpoints []*vapi.Endpoint `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+}
+
+// Membership holds the roles (g rules) and groups (g2 rules) of a user. A
+// user is granted the policies of a role only when it is in both.
+type Membership struct {
+	User   string   `json:"user" yaml:"user"`
+	Roles  []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+// ParseDocument parses a YAML or JSON document.
+func ParseDocument(data []byte) (*Document, error) {
+	doc := &Document{}
+	// JSON is a subset of YAML
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDocument, err)
+	}
+	return doc, nil
+}
+
+// rules validates the document and returns its rules grouped by ptype.
+func (d *Document) rules(adminName string) (map[string][][]string, error) {
+	rules := map[string][][]string{}
+
+	for _, role := range d.Roles {
+		if role == nil || role.Name == "" {
+			return nil, fmt.Errorf("%w: missing role name", ErrInvalidDocument)
+		}
+		for _, endpoint := range role.Endpoints {
+			if endpoint == nil {
+				return nil, fmt.Errorf("%w: role %s: missing endpoint", ErrInvalidDocument, role.Name)
+			}
+			obj, act := parseEndpoint(endpoint)
+			if obj == "" || act == "" {
+				return nil, fmt.Errorf("%w: role %s: endpoint needs an entity or name and a method", ErrInvalidDocument, role.Name)
+			}
+			ptype := api.PType_POLICY.Name()
+			rules[ptype] = append(rules[ptype], []string{role.Name, obj, act})
+		}
+	}
+
+	for _, member := range d.Memberships {
+		if member == nil || member.User == "" {
+			return nil, fmt.Errorf("%w: missing membership user", ErrInvalidDocument)
+		}
+		for _, role := range member.Roles {
+			if role == "" {
+				return nil, fmt.Errorf("%w: user %s: empty role", ErrInvalidDocument, member.User)
+			}
+			ptype := api.PType_ROLE.Name()
+			rules[ptype] = append(rules[ptype], []string{member.User, role})
+		}
+		for _, group := range member.Groups {
+			if group == "" {
+				return nil, fmt.Errorf("%w: user %s: empty group", ErrInvalidDocument, member.User)
+			}
+			ptype := api.PType_GROUP.Name()
+			rules[ptype] = append(rules[ptype], []string{member.User, group})
+		}
+	}
+
+	allowed := map[string]struct{}{}
+	for _, name := range superUsers(adminName) {
+		allowed[name] = struct{}{}
+	}
+	for _, name := range d.SuperUsers {
+		if _, ok := allowed[name]; !ok {
+			return nil, fmt.Errorf("%w: %s can't be a super user, the model only allows %v", ErrInvalidDocument, name, superUsers(adminName))
+		}
+	}
+
+	return rules, nil
+}
+
+// Reconcile converges the rules stored by the adapter to the document: the
+// rules missing from the storage are added, and the ones which are not in
+// the document are removed. It returns the changes, which are only computed
+// when dryRun is set.
+func (r *rbac) Reconcile(ctx context.Context, doc *Document, dryRun bool) (*Diff, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("%w: missing document", ErrInvalidDocument)
+	}
+
+	wanted, err := doc.rules(r.adminName)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.loadModel()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCasbin, err)
+	}
+
+	changes := diffRules(current, wanted, true)
+	diff := newDiff(changes)
+	if dryRun || len(changes) == 0 {
+		return diff, nil
+	}
+
+	if _, err = r.applyChanges(changes); err != nil {
+		return nil, err
+	}
+	if err = r.commit(ctx, "Reconcile", changes...); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+// loadModel loads the rules of the adapter into a copy of the model, which
+// may differ from the enforcer when other replicas share the storage.
+func (r *rbac) loadModel() (model.Model, error) {
+	m := r.e.GetModel().Copy()
+	m.ClearPolicy()
+	if err := r.adp.LoadPolicy(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
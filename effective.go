@@ -0,0 +1,116 @@
+package rbac
+
+import (
+	"sort"
+
+	"github.com/casbin/casbin/v2"
+	casbinrbac "github.com/casbin/casbin/v2/rbac"
+	"github.com/vine-io/rbac/api"
+)
+
+// superUsers returns the subjects which the matcher allows regardless of the policies.
+func superUsers(adminName string) []string {
+	names := []string{"administrator", "root"}
+	if adminName != "" && adminName != "administrator" && adminName != "root" {
+		names = append(names, adminName)
+	}
+	return names
+}
+
+// inheritors returns the subjects other than sub which are granted the
+// policies of sub. Like the matcher, it requires both a g and a g2 link.
+func inheritors(e *casbin.Enforcer, sub string) []string {
+	roles := closure(e.GetNamedRoleManager(api.PType_ROLE.Name()), sub, casbinrbac.RoleManager.GetUsers)
+	groups := closure(e.GetNamedRoleManager(api.PType_GROUP.Name()), sub, casbinrbac.RoleManager.GetUsers)
+
+	return intersect(roles, groups, sub)
+}
+
+// grantors returns the subjects other than sub whose policies are granted to sub.
+func grantors(e *casbin.Enforcer, sub string) []string {
+	return linkedGrantors(e.GetNamedRoleManager(api.PType_ROLE.Name()), e.GetNamedRoleManager(api.PType_GROUP.Name()), sub)
+}
+
+// linkedGrantors returns the grantors of sub by the links of the role
+// managers of the g and g2 rules.
+func linkedGrantors(roleManager, groupManager casbinrbac.RoleManager, sub string) []string {
+	roles := closure(roleManager, sub, casbinrbac.RoleManager.GetRoles)
+	groups := closure(groupManager, sub, casbinrbac.RoleManager.GetRoles)
+
+	return intersect(roles, groups, sub)
+}
+
+// effectivePermissions returns the permissions granted to sub by its own
+// policies and by the policies of its grantors, keyed by object and action.
+func effectivePermissions(e *casbin.Enforcer, sub string) map[string]*Permission {
+	permissions := map[string]*Permission{}
+	// the direct policies go first, so that they win over inherited ones
+	for _, via := range append([]string{sub}, grantors(e, sub)...) {
+		for _, line := range e.GetFilteredPolicy(0, via) {
+			if len(line) < 3 {
+				continue
+			}
+			key := line[1] + "\x00" + line[2]
+			if _, ok := permissions[key]; ok {
+				continue
+			}
+			permissions[key] = &Permission{Subject: sub, Object: line[1], Action: line[2], Via: via}
+		}
+	}
+	return permissions
+}
+
+// maxHierarchyLevel is the number of links followed by the default role
+// manager of casbin, a name further away is not linked by the matcher.
+const maxHierarchyLevel = 10
+
+// closure walks the links of the role manager from name with next, up to
+// maxHierarchyLevel links away as the matcher does, and returns every name
+// which is reached.
+func closure(rm casbinrbac.RoleManager, name string, next func(casbinrbac.RoleManager, string, ...string) ([]string, error)) map[string]struct{} {
+	reached := map[string]struct{}{}
+	if rm == nil {
+		return reached
+	}
+
+	level := []string{name}
+	for depth := 0; depth < maxHierarchyLevel && len(level) > 0; depth++ {
+		var following []string
+		for _, current := range level {
+			// an unknown name has no links
+			names, _ := next(rm, current)
+			for _, n := range names {
+				if _, ok := reached[n]; ok {
+					continue
+				}
+				reached[n] = struct{}{}
+				following = append(following, n)
+			}
+		}
+		level = following
+	}
+
+	return reached
+}
+
+// intersect returns the sorted names which are in both sets, except skip.
+func intersect(a, b map[string]struct{}, skip string) []string {
+	names := make([]string, 0)
+	for name := range a {
+		if _, ok := b[name]; ok && name != skip {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedNames returns the names of the set in order.
+func sortedNames(set map[string]struct{}) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
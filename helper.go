@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/vine-io/rbac/adapter"
+	rbacapi "github.com/vine-io/rbac/api"
 	"github.com/vine-io/vine/lib/api"
 )
 
@@ -29,9 +32,21 @@ m = g(r.sub, p.sub) && g2(r.sub, p.sub) && r.obj == p.obj && r.act == p.act || r
 )
 
 var (
-	ErrAlreadyExists = fmt.Errorf("policy already exists")
-	ErrNotFound      = fmt.Errorf("policy not found")
-	ErrCasbin        = fmt.Errorf("casbin error")
+	// MaxPageSize is the upper bound of the page size of the list APIs.
+	MaxPageSize = 1000
+)
+
+var (
+	ErrAlreadyExists     = fmt.Errorf("policy already exists")
+	ErrNotFound          = fmt.Errorf("policy not found")
+	ErrCasbin            = fmt.Errorf("casbin error")
+	ErrInvalidPageToken  = fmt.Errorf("invalid page token")
+	ErrInvalidPolicyFile = fmt.Errorf("invalid policy file")
+	ErrInvalidDocument   = fmt.Errorf("invalid document")
+	ErrVersionNotFound   = fmt.Errorf("version not found")
+	ErrAuditDisabled     = fmt.Errorf("audit log is disabled")
+	ErrRevisionCompacted = fmt.Errorf("revision is not available")
+	ErrClosed            = fmt.Errorf("rbac is closed")
 )
 
 func parseEndpoint(endpoint *api.Endpoint) (obj string, act string) {
@@ -45,3 +60,80 @@ func parseEndpoint(endpoint *api.Endpoint) (obj string, act string) {
 	act = strings.Join(endpoint.Method, ",")
 	return
 }
+
+// newPolicy builds the policy of a p rule.
+func newPolicy(sub, obj string, act ...string) *rbacapi.Policy {
+	ep := &api.Endpoint{
+		Name:   obj,
+		Method: act,
+		Entity: obj,
+	}
+	return &rbacapi.Policy{
+		Ptype:    rbacapi.PType_POLICY,
+		Sub:      sub,
+		Endpoint: ep,
+	}
+}
+
+// newRule converts a casbin rule to the rule of the adapters.
+func newRule(ptype string, line []string) adapter.Rule {
+	rule := adapter.Rule{PType: ptype}
+	fields := []*string{&rule.V0, &rule.V1, &rule.V2, &rule.V3, &rule.V4, &rule.V5}
+	for i, value := range line {
+		if i < len(fields) {
+			*fields[i] = value
+		}
+	}
+	return rule
+}
+
+// pageLimit returns the page size bounded by MaxPageSize.
+func pageLimit(size int) int {
+	if size <= 0 {
+		return adapter.DefaultPageSize
+	}
+	if size > MaxPageSize {
+		return MaxPageSize
+	}
+	return size
+}
+
+// addPolicies saves the rules with a single call when the adapter supports it,
+// and returns the number of rules added to the storage. Every rule is counted
+// unless the adapter implements adapter.CountingAdapter.
+func addPolicies(adp persist.Adapter, sec, ptype string, rules [][]string) (int, error) {
+	if counting, ok := adp.(adapter.CountingAdapter); ok {
+		n, err := counting.AddPoliciesCount(sec, ptype, rules)
+		return int(n), err
+	}
+	if batch, ok := adp.(persist.BatchAdapter); ok {
+		return len(rules), batch.AddPolicies(sec, ptype, rules)
+	}
+
+	for _, rule := range rules {
+		if err := adp.AddPolicy(sec, ptype, rule); err != nil {
+			return 0, err
+		}
+	}
+	return len(rules), nil
+}
+
+// removePolicies removes the rules with a single call when the adapter supports
+// it, and returns the number of rules removed from the storage, counted as in
+// addPolicies.
+func removePolicies(adp persist.Adapter, sec, ptype string, rules [][]string) (int, error) {
+	if counting, ok := adp.(adapter.CountingAdapter); ok {
+		n, err := counting.RemovePoliciesCount(sec, ptype, rules)
+		return int(n), err
+	}
+	if batch, ok := adp.(persist.BatchAdapter); ok {
+		return len(rules), batch.RemovePolicies(sec, ptype, rules)
+	}
+
+	for _, rule := range rules {
+		if err := adp.RemovePolicy(sec, ptype, rule); err != nil {
+			return 0, err
+		}
+	}
+	return len(rules), nil
+}
@@ -0,0 +1,146 @@
+package rbac
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vine-io/rbac/api"
+	log "github.com/vine-io/vine/lib/logger"
+)
+
+// ReloadOperation is the operation of the changes made by another replica,
+// which are loaded when the watcher calls back.
+const ReloadOperation = "Reload"
+
+// ChangeEvent is a change of the rules, made by a mutation or by another
+// replica.
+type ChangeEvent struct {
+	Time time.Time
+	// Actor is the subject which made the mutation, from the vine metadata of
+	// its context. It is empty for a reload.
+	Actor string
+	// Operation is the method which made the change, such as "AddPolicy", or
+	// ReloadOperation for a change made by another replica.
+	Operation string
+	Reason    string
+	// Revision and Epoch are those of the change sent by Watch.
+	Revision int64
+	Epoch    string
+	// Before holds the changed rules as they were before the change, which
+	// are the removed rules, and After holds them as they are after it, which
+	// are the added rules.
+	Before *Rules
+	After  *Rules
+}
+
+// Rules holds p rules as policies, and g and g2 rules as subjects.
+type Rules struct {
+	Policies []*api.Policy
+	Subjects []*api.Subject
+}
+
+// ChangeListener is called with every change of the rules.
+type ChangeListener func(ev *ChangeEvent)
+
+// OnChange calls the listeners with every change of the rules. They are
+// called in order, one event at a time, in the background once the change is
+// applied, so they may call RBAC. A listener which panics is logged and
+// skipped for that event.
+//
+// The events wait for the listeners in a queue which is not bounded, so
+// that no change is missed: a listener slower than the mutations makes it
+// grow, so a slow listener hands its work off, to a bounded queue of its own
+// for instance.
+func OnChange(listeners ...ChangeListener) Option {
+	return func(c *Config) {
+		c.listeners = append(c.listeners, listeners...)
+	}
+}
+
+// changeHooks queues the events for the listeners.
+type changeHooks struct {
+	listeners []ChangeListener
+
+	mu     sync.Mutex
+	closed bool
+	queue  []*ChangeEvent
+	// signal wakes up run when events are queued
+	signal chan struct{}
+	done   chan struct{}
+}
+
+func newChangeHooks(listeners []ChangeListener) *changeHooks {
+	h := &changeHooks{
+		listeners: listeners,
+		signal:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	go h.run()
+
+	return h
+}
+
+// notify queues the event of the changes. The queue is unbounded, so that
+// a mutation never waits for the listeners.
+func (h *changeHooks) notify(ev *ChangeEvent, changes []ruleChange) {
+	d := newDiff(changes)
+	ev.Time = time.Now()
+	ev.Before = &Rules{Policies: d.RemovedPolicies, Subjects: d.RemovedSubjects}
+	ev.After = &Rules{Policies: d.AddedPolicies, Subjects: d.AddedSubjects}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.queue = append(h.queue, ev)
+
+	select {
+	case h.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (h *changeHooks) run() {
+	defer close(h.done)
+
+	for range h.signal {
+		for {
+			h.mu.Lock()
+			queue := h.queue
+			h.queue = nil
+			h.mu.Unlock()
+			if len(queue) == 0 {
+				break
+			}
+
+			for _, ev := range queue {
+				for _, listener := range h.listeners {
+					call(listener, ev)
+				}
+			}
+		}
+	}
+}
+
+// call calls the listener, and logs its panic so that the other listeners
+// and the next events are still called.
+func call(listener ChangeListener, ev *ChangeEvent) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Errorf("rbac: %s: change listener panicked: %v", ev.Operation, v)
+		}
+	}()
+	listener(ev)
+}
+
+// close calls the listeners with the queued events.
+func (h *changeHooks) close() {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.signal)
+	}
+	h.mu.Unlock()
+	<-h.done
+}
@@ -0,0 +1,150 @@
+package rbac
+
+import (
+	"context"
+
+	casbinrbac "github.com/casbin/casbin/v2/rbac"
+)
+
+// permKey is an object and an action.
+type permKey struct {
+	obj, act string
+}
+
+// permissionIndex maps every subject to its effective permissions, so that
+// Enforce doesn't evaluate the matcher against every p rule. It is built
+// from the enforcer, and updated by the changes of every mutation.
+type permissionIndex struct {
+	// direct holds the p rules of each subject.
+	direct map[string]map[permKey]struct{}
+	// effective holds the permissions granted to each subject by its own p
+	// rules and the ones of its grantors, with the subject granting them.
+	effective map[string]map[permKey]string
+}
+
+// buildIndex compiles the rules of the enforcer.
+func (r *rbac) buildIndex() {
+	index := &permissionIndex{
+		direct:    map[string]map[permKey]struct{}{},
+		effective: map[string]map[permKey]string{},
+	}
+	for _, line := range r.e.GetPolicy() {
+		if len(line) < 3 {
+			continue
+		}
+		index.add(line[0], permKey{obj: line[1], act: line[2]})
+	}
+
+	subjects := map[string]struct{}{}
+	for sub := range index.direct {
+		subjects[sub] = struct{}{}
+		for _, user := range inheritors(r.e, sub) {
+			subjects[user] = struct{}{}
+		}
+	}
+	for sub := range subjects {
+		index.compile(sub, grantors(r.e, sub))
+	}
+
+	r.index = index
+}
+
+// updateIndex applies the changes of a mutation to the index. A p rule
+// changes the permissions of its subject and of the subjects which inherit
+// it. A g or g2 rule may change the permissions of its user, and of the
+// users linked to it by the same ptype.
+func (r *rbac) updateIndex(changes []ruleChange) {
+	affected := map[string]struct{}{}
+	for _, c := range changes {
+		for _, rule := range c.removed {
+			if c.sec == "p" {
+				r.index.remove(rule[0], permKey{obj: rule[1], act: rule[2]})
+			}
+		}
+		for _, rule := range c.added {
+			if c.sec == "p" {
+				r.index.add(rule[0], permKey{obj: rule[1], act: rule[2]})
+			}
+		}
+
+		for _, rules := range [][][]string{c.removed, c.added} {
+			for _, rule := range rules {
+				affected[rule[0]] = struct{}{}
+				if c.sec == "p" {
+					for _, user := range inheritors(r.e, rule[0]) {
+						affected[user] = struct{}{}
+					}
+					continue
+				}
+				users := closure(r.e.GetNamedRoleManager(c.ptype), rule[0], casbinrbac.RoleManager.GetUsers)
+				for user := range users {
+					affected[user] = struct{}{}
+				}
+			}
+		}
+	}
+
+	for sub := range affected {
+		r.index.compile(sub, grantors(r.e, sub))
+	}
+}
+
+func (x *permissionIndex) add(sub string, key permKey) {
+	if x.direct[sub] == nil {
+		x.direct[sub] = map[permKey]struct{}{}
+	}
+	x.direct[sub][key] = struct{}{}
+}
+
+func (x *permissionIndex) remove(sub string, key permKey) {
+	delete(x.direct[sub], key)
+	if len(x.direct[sub]) == 0 {
+		delete(x.direct, sub)
+	}
+}
+
+// compile computes the effective permissions of sub from the p rules of sub
+// and of its grantors.
+func (x *permissionIndex) compile(sub string, grantors []string) {
+	effective := map[permKey]string{}
+	// the direct policies go first, so that they win over inherited ones
+	for _, via := range append([]string{sub}, grantors...) {
+		for key := range x.direct[via] {
+			if _, ok := effective[key]; !ok {
+				effective[key] = via
+			}
+		}
+	}
+
+	if len(effective) == 0 {
+		delete(x.effective, sub)
+		return
+	}
+	x.effective[sub] = effective
+}
+
+// enforce returns whether sub is granted the permission, and the p rule
+// which grants it.
+func (x *permissionIndex) enforce(sub, obj, act string) (bool, []string) {
+	via, ok := x.effective[sub][permKey{obj: obj, act: act}]
+	if !ok {
+		return false, nil
+	}
+	return true, []string{via, obj, act}
+}
+
+// GetImplicitPermissions returns the permissions of sub, granted by its own
+// policies or inherited from its roles and groups, sorted by object and
+// action.
+func (r *rbac) GetImplicitPermissions(ctx context.Context, sub string) []*Permission {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	permissions := make([]*Permission, 0, len(r.index.effective[sub]))
+	for key, via := range r.index.effective[sub] {
+		permissions = append(permissions, &Permission{Subject: sub, Object: key.obj, Action: key.act, Via: via})
+	}
+	sortPermissions(permissions)
+
+	return permissions
+}
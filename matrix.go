@@ -0,0 +1,161 @@
+package rbac
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	casbinrbac "github.com/casbin/casbin/v2/rbac"
+	defaultrolemanager "github.com/casbin/casbin/v2/rbac/default-role-manager"
+	"github.com/vine-io/rbac/api"
+)
+
+// MatrixScope narrows the permission matrix. The zero value selects everything.
+type MatrixScope struct {
+	// Subjects limits the matrix to the given subjects.
+	Subjects []string
+	// ObjPrefix matches the objects which start with it.
+	ObjPrefix string
+}
+
+// Permission is a row of the permission matrix.
+type Permission struct {
+	Subject string `json:"subject"`
+	Object  string `json:"object"`
+	Action  string `json:"action"`
+	// Via is the subject of the policy which grants the permission, it
+	// equals Subject when the policy is granted directly.
+	Via string `json:"via"`
+}
+
+// ExportMatrix writes the effective permissions of every subject to w, one
+// row per (subject, object, action), resolving the roles and groups of the
+// subjects. Super users are not expanded since they can access everything.
+func (r *rbac) ExportMatrix(ctx context.Context, w io.Writer, format api.MatrixFormat, scope MatrixScope) error {
+	var write func(p *Permission) error
+	var flush func() error
+	switch format {
+	case api.MatrixFormat_CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"subject", "object", "action", "via"}); err != nil {
+			return err
+		}
+		write = func(p *Permission) error {
+			return cw.Write([]string{p.Subject, p.Object, p.Action, p.Via})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case api.MatrixFormat_JSON:
+		enc := json.NewEncoder(w)
+		write = func(p *Permission) error { return enc.Encode(p) }
+		flush = func() error { return nil }
+	default:
+		return fmt.Errorf("invalid matrix format")
+	}
+
+	// the rows are computed and written one subject at a time, from a copy
+	// of the rules, so that the lock is not held while writing
+	snapshot := r.matrixSnapshot(scope)
+	for _, sub := range snapshot.names {
+		for _, p := range snapshot.permissions(sub, scope) {
+			if err := write(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+// matrixSnapshot is a copy of the rules which the matrix is computed from.
+type matrixSnapshot struct {
+	// names are the subjects of the matrix, in order
+	names   []string
+	granted map[string][]*api.Policy
+	// roles and groups link the subjects as the g and g2 rules
+	roles, groups casbinrbac.RoleManager
+}
+
+// matrixSnapshot copies the rules and the subjects selected by scope.
+func (r *rbac) matrixSnapshot(scope MatrixScope) *matrixSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	policies, subjects := r.allPolicies()
+
+	s := &matrixSnapshot{
+		granted: map[string][]*api.Policy{},
+		roles:   defaultrolemanager.NewRoleManager(maxHierarchyLevel),
+		groups:  defaultrolemanager.NewRoleManager(maxHierarchyLevel),
+	}
+	names := map[string]struct{}{}
+	for _, p := range policies {
+		s.granted[p.Sub] = append(s.granted[p.Sub], p)
+		names[p.Sub] = struct{}{}
+	}
+	for _, sub := range subjects {
+		names[sub.User] = struct{}{}
+		names[sub.Group] = struct{}{}
+		rm := s.roles
+		if sub.Ptype == api.PType_GROUP {
+			rm = s.groups
+		}
+		_ = rm.AddLink(sub.User, sub.Group)
+	}
+
+	if len(scope.Subjects) > 0 {
+		names = map[string]struct{}{}
+		for _, sub := range scope.Subjects {
+			names[sub] = struct{}{}
+		}
+	}
+	s.names = sortedNames(names)
+
+	return s
+}
+
+// permissions returns the effective permissions of sub selected by scope,
+// sorted by object and action.
+func (s *matrixSnapshot) permissions(sub string, scope MatrixScope) []*Permission {
+	rows := make([]*Permission, 0)
+	seen := map[string]struct{}{}
+	// the direct policies go first, so that they win over inherited ones
+	for _, via := range append([]string{sub}, linkedGrantors(s.roles, s.groups, sub)...) {
+		for _, p := range s.granted[via] {
+			obj, act := parseEndpoint(p.Endpoint)
+			if !strings.HasPrefix(obj, scope.ObjPrefix) {
+				continue
+			}
+			key := obj + "\x00" + act
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			rows = append(rows, &Permission{Subject: sub, Object: obj, Action: act, Via: via})
+		}
+	}
+
+	sortPermissions(rows)
+
+	return rows
+}
+
+// sortPermissions sorts the permissions by subject, object and action.
+func sortPermissions(permissions []*Permission) {
+	sort.SliceStable(permissions, func(i, j int) bool {
+		a, b := permissions[i], permissions[j]
+		if a.Subject != b.Subject {
+			return a.Subject < b.Subject
+		}
+		if a.Object != b.Object {
+			return a.Object < b.Object
+		}
+		return a.Action < b.Action
+	})
+}
@@ -0,0 +1,149 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/vine-io/rbac/api"
+)
+
+// Change is a rule to add or remove, either a policy or a subject.
+type Change struct {
+	// Remove removes the rule instead of adding it.
+	Remove  bool
+	Policy  *api.Policy
+	Subject *api.Subject
+}
+
+// Impact holds the effective permissions gained and lost by some changes.
+type Impact struct {
+	Added   []*Permission
+	Removed []*Permission
+}
+
+// Empty reports whether the changes don't affect any permission.
+func (i *Impact) Empty() bool {
+	return len(i.Added)+len(i.Removed) == 0
+}
+
+// String describes the impact one permission per line, such as
+// "bob loses write on data2".
+func (i *Impact) String() string {
+	var sb strings.Builder
+	for _, p := range i.Removed {
+		sb.WriteString(fmt.Sprintf("%s loses %s on %s\n", p.Subject, p.Action, p.Object))
+	}
+	for _, p := range i.Added {
+		sb.WriteString(fmt.Sprintf("%s gains %s on %s\n", p.Subject, p.Action, p.Object))
+	}
+	return sb.String()
+}
+
+// Plan applies the changes to a copy of the enforcer and returns how they
+// would change the effective permissions of the affected subjects. Nothing
+// is persisted.
+func (r *rbac) Plan(ctx context.Context, changes []*Change) (*Impact, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, err := r.clone()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCasbin, err)
+	}
+
+	affected := map[string]struct{}{}
+	for _, c := range changes {
+		sub, err := applyChange(e, c)
+		if err != nil {
+			return nil, err
+		}
+		affected[sub] = struct{}{}
+	}
+
+	// the subjects which inherit from a changed one, before and after the changes
+	for _, sub := range sortedNames(affected) {
+		for _, enforcer := range []*casbin.Enforcer{r.e, e} {
+			for _, user := range inheritors(enforcer, sub) {
+				affected[user] = struct{}{}
+			}
+		}
+	}
+
+	impact := &Impact{Added: make([]*Permission, 0), Removed: make([]*Permission, 0)}
+	for _, sub := range sortedNames(affected) {
+		before := effectivePermissions(r.e, sub)
+		after := effectivePermissions(e, sub)
+		for key, p := range after {
+			if _, ok := before[key]; !ok {
+				impact.Added = append(impact.Added, p)
+			}
+		}
+		for key, p := range before {
+			if _, ok := after[key]; !ok {
+				impact.Removed = append(impact.Removed, p)
+			}
+		}
+	}
+	sortPermissions(impact.Added)
+	sortPermissions(impact.Removed)
+
+	return impact, nil
+}
+
+// clone returns an enforcer holding a copy of the rules. It has no adapter,
+// so its changes are never persisted.
+func (r *rbac) clone() (*casbin.Enforcer, error) {
+	e, err := casbin.NewEnforcer(r.e.GetModel().Copy())
+	if err != nil {
+		return nil, err
+	}
+	if err = e.BuildRoleLinks(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// applyChange applies the change to the enforcer, and returns the subject
+// whose permissions may be changed.
+func applyChange(e *casbin.Enforcer, c *Change) (string, error) {
+	if c == nil || (c.Policy == nil) == (c.Subject == nil) {
+		return "", fmt.Errorf("change needs either a policy or a subject")
+	}
+
+	var err error
+	if c.Policy != nil {
+		if c.Policy.Sub == "" || c.Policy.Endpoint == nil {
+			return "", fmt.Errorf("missing policy subject or endpoint")
+		}
+		obj, act := parseEndpoint(c.Policy.Endpoint)
+		if c.Remove {
+			_, err = e.RemovePolicy(c.Policy.Sub, obj, act)
+		} else {
+			_, err = e.AddPolicy(c.Policy.Sub, obj, act)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrCasbin, err)
+		}
+		return c.Policy.Sub, nil
+	}
+
+	switch c.Subject.Ptype {
+	case api.PType_ROLE, api.PType_GROUP:
+	default:
+		return "", fmt.Errorf("invalid ptype")
+	}
+	if c.Subject.User == "" || c.Subject.Group == "" {
+		return "", fmt.Errorf("missing subject user or group")
+	}
+	if c.Remove {
+		_, err = e.RemoveNamedGroupingPolicy(c.Subject.Ptype.Name(), c.Subject.User, c.Subject.Group)
+	} else {
+		_, err = e.AddNamedGroupingPolicy(c.Subject.Ptype.Name(), c.Subject.User, c.Subject.Group)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCasbin, err)
+	}
+	return c.Subject.User, nil
+}
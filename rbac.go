@@ -2,38 +2,142 @@ package rbac
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
+	"github.com/vine-io/rbac/adapter"
 	"github.com/vine-io/rbac/api"
 	vapi "github.com/vine-io/vine/lib/api"
+	log "github.com/vine-io/vine/lib/logger"
 )
 
 type RBAC interface {
 	GetAllPolicies(ctx context.Context) ([]*api.Policy, []*api.Subject)
 	GetPolicies(ctx context.Context, sub string) []*api.Policy
+	GetImplicitPermissions(ctx context.Context, sub string) []*Permission
 	AddPolicy(ctx context.Context, p *api.Policy) error
 	DelPolicy(ctx context.Context, p *api.Policy) error
 	GetGroupPolicies(ctx context.Context, p api.PType, sub string) []*api.Subject
 	AddGroupPolicy(ctx context.Context, subject *api.Subject) error
 	DelGroupPolicy(ctx context.Context, subject *api.Subject) error
 	Enforce(ctx context.Context, p *api.Policy) (bool, error)
+	DeleteSubject(ctx context.Context, sub string) (*Deleted, error)
+	DeleteRole(ctx context.Context, role string) (*Deleted, error)
+	RenameSubject(ctx context.Context, oldName, newName string, merge bool) error
+	ListPolicies(ctx context.Context, filter PolicyFilter, pageSize int, pageToken string) ([]*api.Policy, string, error)
+	ListSubjects(ctx context.Context, filter SubjectFilter, pageSize int, pageToken string) ([]*api.Subject, string, error)
+	GetSubjectsForEndpoint(ctx context.Context, endpoint *vapi.Endpoint) (*EndpointSubjects, error)
+	ExportMatrix(ctx context.Context, w io.Writer, format api.MatrixFormat, scope MatrixScope) error
+	Import(ctx context.Context, reader io.Reader, mode api.ImportMode) (*Imported, error)
+	Export(ctx context.Context, w io.Writer) error
+	Reconcile(ctx context.Context, doc *Document, dryRun bool) (*Diff, error)
+	Plan(ctx context.Context, changes []*Change) (*Impact, error)
+	ListVersions(ctx context.Context, pageSize int, pageToken string) ([]*Version, string, error)
+	GetPolicyAt(ctx context.Context, version int64) ([]*api.Policy, []*api.Subject, error)
+	DiffVersions(ctx context.Context, from, to int64) (*Diff, error)
+	Rollback(ctx context.Context, version int64) (*Diff, error)
+	QueryAuditLog(ctx context.Context, query AuditQuery) ([]*AuditRecord, error)
+	Watch(ctx context.Context, epoch string, revision int64) (<-chan *WatchEvent, error)
+	Close() error
+}
+
+// Deleted counts the rules removed by DeleteSubject and DeleteRole.
+type Deleted struct {
+	// Policies is the number of removed p rules.
+	Policies int
+	// Roles is the number of removed g rules.
+	Roles int
+	// Groups is the number of removed g2 rules.
+	Groups int
+}
+
+// PolicyFilter selects the policies returned by ListPolicies.
+type PolicyFilter struct {
+	// SubPrefix matches the subjects which start with it.
+	SubPrefix string
+	Obj       string
+	Act       string
+}
+
+// SubjectFilter selects the grouping rules returned by ListSubjects.
+type SubjectFilter struct {
+	// Ptype is api.PType_ROLE or api.PType_GROUP, both are listed when unset.
+	Ptype api.PType
+	// UserPrefix matches the users which start with it.
+	UserPrefix string
+	Group      string
+}
+
+// EndpointSubjects lists the subjects which can access an endpoint.
+type EndpointSubjects struct {
+	// Direct holds the subjects of the policies which match the endpoint.
+	Direct []string
+	// Inherited holds the users which are granted the policies of Direct
+	// through both g and g2 rules.
+	Inherited []string
+	// SuperUsers can access every endpoint.
+	SuperUsers []string
 }
 
 var _ RBAC = (*rbac)(nil)
 
 type Config struct {
-	adp       persist.Adapter
-	model     model.Model
-	adminName string
+	adp         persist.Adapter
+	model       model.Model
+	adminName   string
+	audit       AuditSink
+	decisionLog *DecisionLogConfig
+	// decisionCache enables the cache of the Enforce results
+	decisionCache *DecisionCacheConfig
+	watcher       persist.Watcher
+	// watchHistory is the number of changes kept to resume a watch
+	watchHistory int
+	// versionHistory is the number of versions kept in memory, when the
+	// adapter doesn't implement adapter.VersionStore
+	versionHistory int
+	listeners      []ChangeListener
+	// migrateGrouping rewrites the grouping rules of the legacy layout
+	migrateGrouping bool
+}
+
+// Option sets an optional field of Config.
+type Option func(*Config)
+
+// WithAuditSink records every mutation to the sink. A failed write is logged
+// and doesn't fail the mutation, which is already applied.
+func WithAuditSink(sink AuditSink) Option {
+	return func(c *Config) {
+		c.audit = sink
+	}
+}
+
+// WithGroupingMigration rewrites the grouping rules stored by earlier releases
+// as "g, <ptype>, user, group" to "<ptype>, user, group" when the RBAC is
+// created. The migration is recorded as a version, in the audit log and by
+// Watch, with the MigrateGroupingPolicies operation. Without it, such rules
+// are kept and logged, and they grant nothing since the enforcer can't match
+// them.
+func WithGroupingMigration() Option {
+	return func(c *Config) {
+		c.migrateGrouping = true
+	}
 }
 
-func NewConfig(adapter persist.Adapter) (Config, error) {
+func NewConfig(adapter persist.Adapter, opts ...Option) (Config, error) {
 	cfg := Config{
 		adp: adapter,
 	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	if err := cfg.configure(); err != nil {
 		return Config{}, err
@@ -57,10 +161,22 @@ func (c *Config) configure() error {
 	return nil
 }
 
+// rbac is safe for concurrent use. Every method holds the lock of the
+// synced enforcer, a read lock for the queries and Enforce, and a write lock
+// for the mutations, so that checking and changing the rules is atomic. The
+// methods then use the unsynchronized enforcer wrapped by it.
 type rbac struct {
 	Config
 
-	e *casbin.Enforcer
+	synced    *casbin.SyncedEnforcer
+	mu        *sync.RWMutex
+	e         *casbin.Enforcer
+	versions  adapter.VersionStore
+	decisions *decisionLog
+	cache     *decisionCache
+	index     *permissionIndex
+	watchers  *watchHub
+	hooks     *changeHooks
 }
 
 func NewRBAC(cfg Config) (RBAC, error) {
@@ -68,7 +184,7 @@ func NewRBAC(cfg Config) (RBAC, error) {
 		return nil, fmt.Errorf("check config: %v", err)
 	}
 
-	e, err := casbin.NewEnforcer(cfg.model, cfg.adp)
+	e, err := casbin.NewSyncedEnforcer(cfg.model, cfg.adp)
 	if err != nil {
 		return nil, err
 	}
@@ -82,11 +198,92 @@ func NewRBAC(cfg Config) (RBAC, error) {
 		return nil, err
 	}
 
-	return &rbac{Config: cfg, e: e}, nil
+	r := &rbac{Config: cfg, synced: e, mu: e.GetLock(), e: e.Enforcer, watchers: newWatchHub(cfg.watchHistory)}
+	if store, ok := cfg.adp.(adapter.VersionStore); ok {
+		r.versions = store
+	} else {
+		r.versions = newMemoryVersions(cfg.versionHistory)
+	}
+	if cfg.decisionLog != nil && cfg.decisionLog.Logger != nil {
+		r.decisions = newDecisionLog(*cfg.decisionLog)
+	}
+	if cfg.decisionCache != nil {
+		r.cache = newDecisionCache(*cfg.decisionCache)
+	}
+	if len(cfg.listeners) > 0 {
+		r.hooks = newChangeHooks(cfg.listeners)
+	}
+	r.buildIndex()
+	if err = r.migrateGroupingPolicies(); err != nil {
+		return nil, err
+	}
+	if cfg.watcher != nil {
+		// the watcher may call back while a mutation holds the lock
+		err = cfg.watcher.SetUpdateCallback(func(string) {
+			go func() {
+				if err := r.reload(); err != nil {
+					log.Errorf("rbac: reload the rules: %v", err)
+				}
+			}()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// migrateGroupingPolicies moves grouping rules stored as "g, <ptype>, user, group"
+// by earlier releases to their own "g" and "g2" ptypes, when the migration is
+// enabled. It is applied and recorded as a mutation, so either every rule is
+// moved or none of them.
+func (r *rbac) migrateGroupingPolicies() error {
+	legacy := make([][]string, 0)
+	named := map[string][][]string{}
+	for _, group := range r.e.GetGroupingPolicy() {
+		if len(group) != 3 {
+			continue
+		}
+		switch ptype := api.ParsePtype(group[0]); ptype {
+		case api.PType_ROLE, api.PType_GROUP:
+			legacy = append(legacy, group)
+			if !r.e.HasNamedGroupingPolicy(ptype.Name(), group[1:]) {
+				named[ptype.Name()] = append(named[ptype.Name()], group[1:])
+			}
+		}
+	}
+
+	if len(legacy) == 0 {
+		return nil
+	}
+	if !r.migrateGrouping {
+		log.Warnf("rbac: %d grouping rules use the legacy layout and grant nothing, enable WithGroupingMigration to migrate them", len(legacy))
+		return nil
+	}
+
+	changes := []ruleChange{{sec: "g", ptype: api.PType_ROLE.Name(), removed: legacy, added: named[api.PType_ROLE.Name()]}}
+	if rules := named[api.PType_GROUP.Name()]; len(rules) > 0 {
+		changes = append(changes, ruleChange{sec: "g", ptype: api.PType_GROUP.Name(), added: rules})
+	}
+	if _, err := r.applyChanges(changes); err != nil {
+		return fmt.Errorf("migrate grouping policies: %w", err)
+	}
+	if err := r.commit(context.Background(), "MigrateGroupingPolicies", changes...); err != nil {
+		return fmt.Errorf("migrate grouping policies: %w", err)
+	}
+
+	return nil
 }
 
 func (r *rbac) GetAllPolicies(ctx context.Context) ([]*api.Policy, []*api.Subject) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.allPolicies()
+}
 
+func (r *rbac) allPolicies() ([]*api.Policy, []*api.Subject) {
 	policies := make([]*api.Policy, 0)
 	subjects := make([]*api.Subject, 0)
 
@@ -95,36 +292,19 @@ func (r *rbac) GetAllPolicies(ctx context.Context) ([]*api.Policy, []*api.Subjec
 		if len(line) < 3 {
 			continue
 		}
-		ep := &vapi.Endpoint{
-			Name:   line[1],
-			Method: line[2:],
-			Entity: line[1],
-		}
-		p := &api.Policy{
-			Ptype:    api.PType_POLICY,
-			Sub:      line[0],
-			Endpoint: ep,
-		}
-		policies = append(policies, p)
+		policies = append(policies, newPolicy(line[0], line[1], line[2:]...))
 	}
 
-	groups := r.e.GetGroupingPolicy()
-	for _, group := range groups {
-		if len(group) < 3 {
-			continue
-		}
-		s := &api.Subject{
-			Ptype: api.ParsePtype(group[0]),
-			User:  group[1],
-			Group: group[2],
-		}
-		subjects = append(subjects, s)
+	for _, ptype := range []api.PType{api.PType_ROLE, api.PType_GROUP} {
+		subjects = append(subjects, r.groupPolicies(ptype, "")...)
 	}
 
 	return policies, subjects
 }
 
 func (r *rbac) GetPolicies(ctx context.Context, sub string) []*api.Policy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
 	policies := make([]*api.Policy, 0)
 
@@ -133,17 +313,7 @@ func (r *rbac) GetPolicies(ctx context.Context, sub string) []*api.Policy {
 		if len(line) < 3 {
 			continue
 		}
-		ep := &vapi.Endpoint{
-			Name:   line[1],
-			Method: line[2:],
-			Entity: line[1],
-		}
-		p := &api.Policy{
-			Ptype:    api.PType_POLICY,
-			Sub:      line[0],
-			Endpoint: ep,
-		}
-		policies = append(policies, p)
+		policies = append(policies, newPolicy(line[0], line[1], line[2:]...))
 	}
 
 	return policies
@@ -152,6 +322,9 @@ func (r *rbac) GetPolicies(ctx context.Context, sub string) []*api.Policy {
 func (r *rbac) AddPolicy(ctx context.Context, p *api.Policy) error {
 	obj, act := parseEndpoint(p.Endpoint)
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	ok, err := r.e.AddPolicy(p.Sub, obj, act)
 	if err != nil {
 		return fmt.Errorf("%w: %v", ErrCasbin, err)
@@ -161,12 +334,15 @@ func (r *rbac) AddPolicy(ctx context.Context, p *api.Policy) error {
 		return ErrAlreadyExists
 	}
 
-	return nil
+	return r.commit(ctx, "AddPolicy", ruleChange{sec: "p", ptype: api.PType_POLICY.Name(), added: [][]string{{p.Sub, obj, act}}})
 }
 
 func (r *rbac) DelPolicy(ctx context.Context, p *api.Policy) error {
 	obj, act := parseEndpoint(p.Endpoint)
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	ok, err := r.e.RemovePolicy(p.Sub, obj, act)
 	if err != nil {
 		return fmt.Errorf("%w: %v", ErrCasbin, err)
@@ -176,21 +352,36 @@ func (r *rbac) DelPolicy(ctx context.Context, p *api.Policy) error {
 		return ErrNotFound
 	}
 
-	return nil
+	return r.commit(ctx, "DelPolicy", ruleChange{sec: "p", ptype: api.PType_POLICY.Name(), removed: [][]string{{p.Sub, obj, act}}})
 }
 
+// GetGroupPolicies returns the grouping rules of ptype p whose user is sub, or
+// all of them when sub is empty. It returns nothing for the other ptypes.
 func (r *rbac) GetGroupPolicies(ctx context.Context, p api.PType, sub string) []*api.Subject {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.groupPolicies(p, sub)
+}
+
+func (r *rbac) groupPolicies(p api.PType, sub string) []*api.Subject {
 	subjects := make([]*api.Subject, 0)
 
-	groups := r.e.GetFilteredNamedGroupingPolicy(p.Name(), 1, sub)
+	switch p {
+	case api.PType_ROLE, api.PType_GROUP:
+	default:
+		return subjects
+	}
+
+	groups := r.e.GetFilteredNamedGroupingPolicy(p.Name(), 0, sub)
 	for _, group := range groups {
-		if len(group) < 3 {
+		if len(group) < 2 {
 			continue
 		}
 		s := &api.Subject{
-			Ptype: api.ParsePtype(group[0]),
-			User:  group[1],
-			Group: group[2],
+			Ptype: p,
+			User:  group[0],
+			Group: group[1],
 		}
 		subjects = append(subjects, s)
 	}
@@ -208,7 +399,10 @@ func (r *rbac) AddGroupPolicy(ctx context.Context, subject *api.Subject) error {
 		return fmt.Errorf("invalid ptype")
 	}
 
-	ok, err := r.e.AddGroupingPolicy(ptype, subject.User, subject.Group)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ok, err := r.e.AddNamedGroupingPolicy(ptype, subject.User, subject.Group)
 	if err != nil {
 		return fmt.Errorf("%w: %v", ErrCasbin, err)
 	}
@@ -217,7 +411,7 @@ func (r *rbac) AddGroupPolicy(ctx context.Context, subject *api.Subject) error {
 		return ErrAlreadyExists
 	}
 
-	return nil
+	return r.commit(ctx, "AddGroupPolicy", ruleChange{sec: "g", ptype: ptype, added: [][]string{{subject.User, subject.Group}}})
 }
 
 func (r *rbac) DelGroupPolicy(ctx context.Context, subject *api.Subject) error {
@@ -230,7 +424,10 @@ func (r *rbac) DelGroupPolicy(ctx context.Context, subject *api.Subject) error {
 		return fmt.Errorf("invalid ptype")
 	}
 
-	ok, err := r.e.RemoveGroupingPolicy(ptype, subject.User, subject.Group)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ok, err := r.e.RemoveNamedGroupingPolicy(ptype, subject.User, subject.Group)
 	if err != nil {
 		return fmt.Errorf("%w: %v", ErrCasbin, err)
 	}
@@ -239,17 +436,517 @@ func (r *rbac) DelGroupPolicy(ctx context.Context, subject *api.Subject) error {
 		return ErrNotFound
 	}
 
-	return nil
+	return r.commit(ctx, "DelGroupPolicy", ruleChange{sec: "g", ptype: ptype, removed: [][]string{{subject.User, subject.Group}}})
 }
 
 func (r *rbac) Enforce(ctx context.Context, p *api.Policy) (bool, error) {
 
 	obj, act := parseEndpoint(p.Endpoint)
 
-	ok, err := r.e.Enforce(p.Sub, obj, act)
-	if err != nil {
-		return false, fmt.Errorf("%w: %v", ErrCasbin, err)
+	start := time.Now()
+	ok, rule := r.enforce(obj, act, p.Sub)
+
+	if r.decisions != nil {
+		d := &Decision{
+			Time:    start,
+			Subject: p.Sub,
+			Object:  obj,
+			Action:  act,
+			Allowed: ok,
+			Rule:    rule,
+			Latency: time.Since(start),
+		}
+		r.decisions.log(d)
 	}
 
 	return ok, nil
 }
+
+// enforce returns the cached result of the request, or looks it up in the
+// permission index, which mirrors the matcher of DefaultModel. It also
+// returns the p rule which allows the request, the super users are allowed
+// without one. The result is cached while holding the read lock, so that a
+// mutation can't be applied between the lookup and the caching.
+func (r *rbac) enforce(obj, act, sub string) (bool, []string) {
+	if r.isSuperUser(sub) {
+		return true, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.cache == nil {
+		return r.index.enforce(sub, obj, act)
+	}
+
+	key := cacheKey{sub: sub, obj: obj, act: act}
+	if entry, ok := r.cache.get(key); ok {
+		return entry.allowed, entry.rule
+	}
+	ok, rule := r.index.enforce(sub, obj, act)
+	r.cache.put(key, ok, rule)
+	return ok, rule
+}
+
+// isSuperUser reports whether the matcher allows sub regardless of the policies.
+func (r *rbac) isSuperUser(sub string) bool {
+	for _, name := range superUsers(r.adminName) {
+		if sub == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Close flushes the pending decisions and stops the background workers.
+func (r *rbac) Close() error {
+	r.watchers.close()
+	if r.hooks != nil {
+		r.hooks.close()
+	}
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+	if r.decisions != nil {
+		return r.decisions.close()
+	}
+	return nil
+}
+
+// DeleteSubject removes every p, g and g2 rule which references the given subject.
+func (r *rbac) DeleteSubject(ctx context.Context, sub string) (*Deleted, error) {
+	if sub == "" {
+		return nil, fmt.Errorf("missing subject")
+	}
+
+	return r.deleteName(ctx, "DeleteSubject", sub)
+}
+
+// DeleteRole removes the rules granted to role, and every g and g2 rule which
+// assigns the role to (or inherits it from) another subject.
+func (r *rbac) DeleteRole(ctx context.Context, role string) (*Deleted, error) {
+	if role == "" {
+		return nil, fmt.Errorf("missing role")
+	}
+
+	return r.deleteName(ctx, "DeleteRole", role)
+}
+
+// deleteName removes every rule which references name with one adapter call
+// per ptype. When a call fails, the rules removed by the previous ones are
+// restored, so either every rule is removed or none of them.
+func (r *rbac) deleteName(ctx context.Context, operation, name string) (*Deleted, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.e.GetModel()
+	deleted := &Deleted{}
+	changes := make([]ruleChange, 0, 3)
+
+	if policies := m.GetFilteredPolicy("p", api.PType_POLICY.Name(), 0, name); len(policies) > 0 {
+		deleted.Policies = len(policies)
+		changes = append(changes, ruleChange{sec: "p", ptype: api.PType_POLICY.Name(), removed: policies})
+	}
+
+	for _, ptype := range []api.PType{api.PType_ROLE, api.PType_GROUP} {
+		// the rules where name is the user, then those where it is the group
+		removed := m.GetFilteredPolicy("g", ptype.Name(), 0, name)
+		for _, rule := range m.GetFilteredPolicy("g", ptype.Name(), 1, name) {
+			if rule[0] != name {
+				removed = append(removed, rule)
+			}
+		}
+		if len(removed) == 0 {
+			continue
+		}
+
+		if ptype == api.PType_ROLE {
+			deleted.Roles = len(removed)
+		} else {
+			deleted.Groups = len(removed)
+		}
+		changes = append(changes, ruleChange{sec: "g", ptype: ptype.Name(), removed: removed})
+	}
+
+	if len(changes) == 0 {
+		return nil, ErrNotFound
+	}
+
+	// the rules are removed by applyChanges rather than by RemoveFilteredPolicy:
+	// a g rule references name as its user or its group, which one filter
+	// can't match, and the removed rules are needed for the counts, the
+	// version and the events. applyChanges writes them in a single
+	// transaction when the adapter supports it, and reverts them otherwise.
+	if _, err := r.applyChanges(changes); err != nil {
+		return nil, err
+	}
+	if err := r.commit(ctx, operation, changes...); err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// RenameSubject rewrites every p, g and g2 rule which references oldName to
+// reference newName instead. It fails with ErrAlreadyExists when newName
+// already has rules, unless merge is set, in which case the rules of both
+// names are combined. Either all rules are renamed or none of them: the
+// rules are written in a single transaction when the adapter implements
+// adapter.TransactionalAdapter, otherwise the renamed ones are reverted after
+// a failure, and an error of the revert is returned.
+func (r *rbac) RenameSubject(ctx context.Context, oldName, newName string, merge bool) error {
+	if oldName == "" || newName == "" {
+		return fmt.Errorf("missing subject")
+	}
+	if oldName == newName {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.hasName(oldName) {
+		return ErrNotFound
+	}
+	if !merge && r.hasName(newName) {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, newName)
+	}
+
+	m := r.e.GetModel()
+	done := make([]ruleChange, 0)
+	atomic, err := r.transaction(func(adp persist.Adapter) error {
+		for _, slot := range nameSlots {
+			oldRules := m.GetFilteredPolicy(slot.sec, slot.ptype, slot.fieldIndex, oldName)
+			if len(oldRules) == 0 {
+				continue
+			}
+
+			newRules := make([][]string, 0, len(oldRules))
+			seen := map[string]struct{}{}
+			for _, rule := range oldRules {
+				renamed := append([]string{}, rule...)
+				renamed[slot.fieldIndex] = newName
+				key := strings.Join(renamed, ",")
+				if _, ok := seen[key]; ok || m.HasPolicy(slot.sec, slot.ptype, renamed) {
+					continue
+				}
+				seen[key] = struct{}{}
+				newRules = append(newRules, renamed)
+			}
+
+			if err := r.updateFiltered(adp, slot.sec, slot.ptype, slot.fieldIndex, oldName, oldRules, newRules); err != nil {
+				return err
+			}
+			done = append(done, ruleChange{sec: slot.sec, ptype: slot.ptype, removed: oldRules, added: newRules})
+		}
+		return nil
+	})
+	if err != nil {
+		return r.undo(err, atomic, done)
+	}
+
+	return r.commit(ctx, "RenameSubject", done...)
+}
+
+// nameSlots lists every field of a rule which holds a subject name.
+var nameSlots = []struct {
+	sec        string
+	ptype      string
+	fieldIndex int
+}{
+	{"p", "p", 0},
+	{"g", "g", 0},
+	{"g", "g", 1},
+	{"g", "g2", 0},
+	{"g", "g2", 1},
+}
+
+// hasName reports whether any rule references the given subject name.
+func (r *rbac) hasName(name string) bool {
+	m := r.e.GetModel()
+	for _, slot := range nameSlots {
+		if len(m.GetFilteredPolicy(slot.sec, slot.ptype, slot.fieldIndex, name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// updateFiltered replaces the rules whose field at fieldIndex equals value
+// with newRules, using a single UpdateFilteredPolicies call of the adapter.
+func (r *rbac) updateFiltered(adp persist.Adapter, sec, ptype string, fieldIndex int, value string, oldRules, newRules [][]string) error {
+	updatable, ok := adp.(persist.UpdatableAdapter)
+	if !ok {
+		_, _, err := r.replaceRules(adp, sec, ptype, oldRules, newRules)
+		return err
+	}
+
+	if _, err := updatable.UpdateFilteredPolicies(sec, ptype, newRules, fieldIndex, value); err != nil {
+		return err
+	}
+
+	return r.updateModel(sec, ptype, oldRules, newRules)
+}
+
+// replaceRules removes and adds the given rules in the storage of adp and in
+// the model, and returns the number of rules removed from and added to the
+// storage.
+func (r *rbac) replaceRules(adp persist.Adapter, sec, ptype string, removed, added [][]string) (removedN, addedN int, err error) {
+	if len(removed) > 0 {
+		if removedN, err = removePolicies(adp, sec, ptype, removed); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(added) > 0 {
+		if addedN, err = addPolicies(adp, sec, ptype, added); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return removedN, addedN, r.updateModel(sec, ptype, removed, added)
+}
+
+// transaction calls fn with an adapter whose writes are committed together
+// when fn succeeds and rolled back otherwise, when the adapter implements
+// adapter.TransactionalAdapter, and reports whether it does. Otherwise fn
+// writes to the adapter directly.
+func (r *rbac) transaction(fn func(adp persist.Adapter) error) (bool, error) {
+	if tx, ok := r.adp.(adapter.TransactionalAdapter); ok {
+		return true, tx.WithTransaction(fn)
+	}
+	return false, fn(r.adp)
+}
+
+// undo reverts the changes applied before err: only in the model when their
+// writes were rolled back by the transaction, otherwise in the storage as
+// well. The error of the revert is returned along with err, since the model
+// or the storage then keep a part of the changes.
+func (r *rbac) undo(err error, atomic bool, applied []ruleChange) error {
+	var revertErr error
+	if atomic {
+		revertErr = r.revertModel(applied)
+	} else {
+		revertErr = r.revertChanges(applied)
+	}
+	if revertErr != nil {
+		return fmt.Errorf("%w: %v, revert: %v", ErrCasbin, err, revertErr)
+	}
+	return fmt.Errorf("%w: %v", ErrCasbin, err)
+}
+
+// ruleChange holds the rules of a ptype which are removed and added together.
+type ruleChange struct {
+	sec, ptype string
+	removed    [][]string
+	added      [][]string
+}
+
+// applyChanges applies the changes in order, and counts the rules which are
+// actually added to and removed from the storage. The changes are written in
+// a single transaction when the adapter supports it. Otherwise, when one of
+// them fails, the changes which have been applied are reverted, so either
+// all the changes are applied or none of them.
+func (r *rbac) applyChanges(changes []ruleChange) (*Imported, error) {
+	applied := &Imported{}
+	done := 0
+	atomic, err := r.transaction(func(adp persist.Adapter) error {
+		for _, c := range changes {
+			removed, added, err := r.replaceRules(adp, c.sec, c.ptype, c.removed, c.added)
+			if err != nil {
+				return err
+			}
+			applied.Added += added
+			applied.Removed += removed
+			done++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, r.undo(err, atomic, changes[:done])
+	}
+	return applied, nil
+}
+
+// revertChanges reverts the applied changes in reverse order, in the storage
+// and in the model. It goes on after a failure, and returns the first error.
+func (r *rbac) revertChanges(applied []ruleChange) error {
+	var first error
+	for i := len(applied) - 1; i >= 0; i-- {
+		if _, _, err := r.replaceRules(r.adp, applied[i].sec, applied[i].ptype, applied[i].added, applied[i].removed); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// revertModel reverts the applied changes in reverse order in the model
+// only, once their writes are rolled back.
+func (r *rbac) revertModel(applied []ruleChange) error {
+	var first error
+	for i := len(applied) - 1; i >= 0; i-- {
+		if err := r.updateModel(applied[i].sec, applied[i].ptype, applied[i].added, applied[i].removed); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// updateModel applies the changes to the in-memory model and role links
+// without persisting them.
+func (r *rbac) updateModel(sec, ptype string, removed, added [][]string) error {
+	m := r.e.GetModel()
+	m.RemovePolicies(sec, ptype, removed)
+	m.AddPolicies(sec, ptype, added)
+
+	if sec != "g" {
+		return nil
+	}
+	if err := r.e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, removed); err != nil {
+		return err
+	}
+	return r.e.BuildIncrementalRoleLinks(model.PolicyAdd, ptype, added)
+}
+
+// ListPolicies returns a page of the policies which match the filter, and the
+// token of the next page. The filters are pushed down to the adapter when it
+// implements adapter.Lister.
+func (r *rbac) ListPolicies(ctx context.Context, filter PolicyFilter, pageSize int, pageToken string) ([]*api.Policy, string, error) {
+	query := adapter.Query{
+		PType:    []string{api.PType_POLICY.Name()},
+		V0Prefix: filter.SubPrefix,
+		V1:       filter.Obj,
+		V2:       filter.Act,
+		Limit:    pageLimit(pageSize),
+		Token:    pageToken,
+	}
+
+	rules, next, err := r.listRules(query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	policies := make([]*api.Policy, 0, len(rules))
+	for _, rule := range rules {
+		policies = append(policies, newPolicy(rule.V0, rule.V1, rule.V2))
+	}
+
+	return policies, next, nil
+}
+
+// ListSubjects returns a page of the grouping rules which match the filter,
+// and the token of the next page.
+func (r *rbac) ListSubjects(ctx context.Context, filter SubjectFilter, pageSize int, pageToken string) ([]*api.Subject, string, error) {
+	query := adapter.Query{
+		V0Prefix: filter.UserPrefix,
+		V1:       filter.Group,
+		Limit:    pageLimit(pageSize),
+		Token:    pageToken,
+	}
+	switch filter.Ptype {
+	case api.PType_ROLE, api.PType_GROUP:
+		query.PType = []string{filter.Ptype.Name()}
+	case api.PType_UNKNOWN:
+		query.PType = []string{api.PType_ROLE.Name(), api.PType_GROUP.Name()}
+	default:
+		return nil, "", fmt.Errorf("invalid ptype")
+	}
+
+	rules, next, err := r.listRules(query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	subjects := make([]*api.Subject, 0, len(rules))
+	for _, rule := range rules {
+		subjects = append(subjects, &api.Subject{
+			Ptype: api.ParsePtype(rule.PType),
+			User:  rule.V0,
+			Group: rule.V1,
+		})
+	}
+
+	return subjects, next, nil
+}
+
+// listRules pages through the rules of the adapter, or through the rules of
+// the enforcer when the adapter can't do it.
+func (r *rbac) listRules(query adapter.Query) ([]adapter.Rule, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if lister, ok := r.adp.(adapter.Lister); ok {
+		rules, next, err := lister.ListRules(query)
+		if err != nil {
+			if errors.Is(err, adapter.ErrInvalidPageToken) {
+				return nil, "", ErrInvalidPageToken
+			}
+			return nil, "", fmt.Errorf("%w: %v", ErrCasbin, err)
+		}
+		return rules, next, nil
+	}
+
+	offset := 0
+	if query.Token != "" {
+		n, err := strconv.Atoi(query.Token)
+		if err != nil || n < 0 {
+			return nil, "", ErrInvalidPageToken
+		}
+		offset = n
+	}
+
+	rules := make([]adapter.Rule, 0, query.Limit)
+	matched := 0
+	m := r.e.GetModel()
+	for _, ptype := range query.PType {
+		for _, line := range m.GetFilteredPolicy(ptype[:1], ptype, 1, query.V1, query.V2) {
+			if !strings.HasPrefix(line[0], query.V0Prefix) {
+				continue
+			}
+			matched++
+			if matched <= offset {
+				continue
+			}
+			if len(rules) == query.Limit {
+				return rules, strconv.Itoa(offset + len(rules)), nil
+			}
+			rules = append(rules, newRule(ptype, line))
+		}
+	}
+
+	return rules, "", nil
+}
+
+// GetSubjectsForEndpoint returns the subjects which are allowed to access the
+// endpoint, either by their own policies or by inheriting them.
+func (r *rbac) GetSubjectsForEndpoint(ctx context.Context, endpoint *vapi.Endpoint) (*EndpointSubjects, error) {
+	if endpoint == nil {
+		return nil, fmt.Errorf("missing endpoint")
+	}
+
+	obj, act := parseEndpoint(endpoint)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	direct := map[string]struct{}{}
+	for _, line := range r.e.GetFilteredPolicy(1, obj, act) {
+		direct[line[0]] = struct{}{}
+	}
+
+	inherited := map[string]struct{}{}
+	for sub := range direct {
+		for _, user := range inheritors(r.e, sub) {
+			if _, ok := direct[user]; !ok {
+				inherited[user] = struct{}{}
+			}
+		}
+	}
+
+	subjects := &EndpointSubjects{
+		Direct:     sortedNames(direct),
+		Inherited:  sortedNames(inherited),
+		SuperUsers: superUsers(r.adminName),
+	}
+
+	return subjects, nil
+}
@@ -1,14 +1,26 @@
 package rbac
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/stretchr/testify/assert"
 	"github.com/vine-io/rbac/adapter"
 	"github.com/vine-io/rbac/api"
 	vapi "github.com/vine-io/vine/lib/api"
+	"github.com/vine-io/vine/util/context/metadata"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -93,3 +105,1496 @@ func TestNewRBAC(t *testing.T) {
 	t.Log(r.GetPolicies(ctx, "lack"))
 	t.Log(r.GetGroupPolicies(ctx, api.PType_ROLE, "lack"))
 }
+
+func newTestRBAC(t *testing.T) RBAC {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewConfig(apt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestDeleteSubject(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("lack", "user", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("lack", "user", "write")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("bob", "user", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "lack", Group: "dev"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "lack"}))
+
+	deleted, err := r.DeleteSubject(ctx, "lack")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &Deleted{Policies: 2, Roles: 2, Groups: 1}, deleted)
+
+	policies, subjects := r.GetAllPolicies(ctx)
+	assert.Len(t, policies, 1)
+	assert.Len(t, subjects, 0)
+
+	_, err = r.DeleteSubject(ctx, "lack")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDeleteRole(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "user", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "lack", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "reader"}))
+
+	deleted, err := r.DeleteRole(ctx, "reader")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &Deleted{Policies: 1, Roles: 2, Groups: 1}, deleted)
+
+	ok, err := r.Enforce(ctx, api.NewPolicyWithString("lack", "user", "read"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// groupless is an adapter which fails to remove the g2 rules.
+type groupless struct {
+	*adapter.GormAdapter
+}
+
+func (a *groupless) RemovePoliciesCount(sec, ptype string, rules [][]string) (int64, error) {
+	if ptype == api.PType_GROUP.Name() {
+		return 0, errors.New("unavailable")
+	}
+	return a.GormAdapter.RemovePoliciesCount(sec, ptype, rules)
+}
+
+func (a *groupless) UpdateFilteredPolicies(sec, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	if ptype == api.PType_GROUP.Name() {
+		return nil, errors.New("unavailable")
+	}
+	return a.GormAdapter.UpdateFilteredPolicies(sec, ptype, newRules, fieldIndex, fieldValues...)
+}
+
+func (a *groupless) WithTransaction(fn func(tx persist.Adapter) error) error {
+	return a.GormAdapter.WithTransaction(func(tx persist.Adapter) error {
+		return fn(&groupless{GormAdapter: tx.(*adapter.GormAdapter)})
+	})
+}
+
+// untransactional is an adapter without transactions, which fails to add
+// the g2 rules and the rules of lack.
+type untransactional struct {
+	persist.BatchAdapter
+}
+
+func (a *untransactional) AddPolicies(sec, ptype string, rules [][]string) error {
+	for _, rule := range rules {
+		if ptype == api.PType_GROUP.Name() || rule[0] == "lack" {
+			return errors.New("unavailable")
+		}
+	}
+	return a.BatchAdapter.AddPolicies(sec, ptype, rules)
+}
+
+func TestDeleteSubjectFailure(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewConfig(&groupless{GormAdapter: apt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx := context.TODO()
+	p := api.NewPolicyWithString("lack", "user", "read")
+	assert.NoError(t, r.AddPolicy(ctx, p))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "lack", Group: "dev"}))
+
+	// the policies and roles removed before the failure are restored
+	_, err = r.DeleteSubject(ctx, "lack")
+	assert.ErrorIs(t, err, ErrCasbin)
+
+	policies, subjects := r.GetAllPolicies(ctx)
+	assert.Len(t, policies, 1)
+	assert.Len(t, subjects, 2)
+	ok, err := r.Enforce(ctx, p)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	rules, _, err := apt.ListRules(adapter.Query{})
+	assert.NoError(t, err)
+	assert.Len(t, rules, 3)
+}
+
+func TestRenameSubjectFailure(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewConfig(&groupless{GormAdapter: apt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx := context.TODO()
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("lack", "user", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "lack", Group: "dev"}))
+
+	// the writes of the policies and roles are rolled back with the g2 rules
+	assert.ErrorIs(t, r.RenameSubject(ctx, "lack", "bob", false), ErrCasbin)
+	assert.Len(t, r.GetPolicies(ctx, "lack"), 1)
+	assert.Len(t, r.GetPolicies(ctx, "bob"), 0)
+	rules, _, err := apt.ListRules(adapter.Query{})
+	assert.NoError(t, err)
+	if assert.Len(t, rules, 3) {
+		for _, rule := range rules {
+			assert.Equal(t, "lack", rule.V0)
+		}
+	}
+
+	// without a transaction, the failure to revert is returned
+	db, err = gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err = adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, apt.AddPolicy("p", "p", []string{"lack", "user", "read"}))
+	assert.NoError(t, apt.AddPolicy("g", "g2", []string{"lack", "dev"}))
+	cfg, err = NewConfig(&untransactional{BatchAdapter: apt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = r.RenameSubject(ctx, "lack", "bob", false)
+	assert.ErrorIs(t, err, ErrCasbin)
+	assert.ErrorContains(t, err, "revert")
+}
+
+func TestMigrateGroupingPolicies(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// grouping rules written by earlier releases
+	assert.NoError(t, apt.AddPolicy("g", "g", []string{"g", "lack", "reader"}))
+	assert.NoError(t, apt.AddPolicy("g", "g", []string{"g2", "lack", "reader"}))
+
+	// the legacy rules are kept until the migration is enabled
+	cfg, err := NewConfig(apt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, legacy.Close())
+	rules, _, err := apt.ListRules(adapter.Query{PType: []string{"g"}})
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	changed := make(chan *ChangeEvent, 1)
+	cfg, err = NewConfig(apt, WithGroupingMigration(), OnChange(func(ev *ChangeEvent) { changed <- ev }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the migration is recorded as a mutation
+	ctx := context.TODO()
+	select {
+	case ev := <-changed:
+		assert.Equal(t, "MigrateGroupingPolicies", ev.Operation)
+		assert.Len(t, ev.After.Subjects, 2)
+	case <-time.After(time.Second):
+		t.Fatal("migration not notified")
+	}
+	versions, _, err := r.ListVersions(ctx, 0, "")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.Equal(t, []*api.Subject{{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}}, r.GetGroupPolicies(ctx, api.PType_ROLE, "lack"))
+	assert.Equal(t, []*api.Subject{{Ptype: api.PType_GROUP, User: "lack", Group: "reader"}}, r.GetGroupPolicies(ctx, api.PType_GROUP, "lack"))
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "user", "read")))
+	ok, err := r.Enforce(ctx, api.NewPolicyWithString("lack", "user", "read"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestGetGroupPolicies(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "lack"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "lack", Group: "dev"}))
+
+	// the rules are filtered by their user, not their group
+	assert.Equal(t, []*api.Subject{{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}}, r.GetGroupPolicies(ctx, api.PType_ROLE, "lack"))
+	assert.Equal(t, []*api.Subject{{Ptype: api.PType_GROUP, User: "lack", Group: "dev"}}, r.GetGroupPolicies(ctx, api.PType_GROUP, "lack"))
+	assert.Len(t, r.GetGroupPolicies(ctx, api.PType_ROLE, ""), 2)
+	assert.Len(t, r.GetGroupPolicies(ctx, api.PType_POLICY, "lack"), 0)
+}
+
+func TestRenameSubject(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("dev", "user", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("ops", "user", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "dev"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "lack", Group: "dev"}))
+
+	assert.ErrorIs(t, r.RenameSubject(ctx, "dev", "ops", false), ErrAlreadyExists)
+	assert.ErrorIs(t, r.RenameSubject(ctx, "nobody", "ops", false), ErrNotFound)
+
+	assert.NoError(t, r.RenameSubject(ctx, "dev", "developer", false))
+	assert.Len(t, r.GetPolicies(ctx, "dev"), 0)
+	assert.Len(t, r.GetPolicies(ctx, "developer"), 1)
+	assert.Equal(t, []*api.Subject{{Ptype: api.PType_ROLE, User: "lack", Group: "developer"}}, r.GetGroupPolicies(ctx, api.PType_ROLE, "lack"))
+
+	ok, err := r.Enforce(ctx, api.NewPolicyWithString("lack", "user", "read"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, r.RenameSubject(ctx, "developer", "ops", true))
+	assert.Len(t, r.GetPolicies(ctx, "developer"), 0)
+	assert.Len(t, r.GetPolicies(ctx, "ops"), 1)
+	assert.Len(t, r.GetGroupPolicies(ctx, api.PType_GROUP, "lack"), 1)
+}
+
+func TestListPolicies(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	for _, sub := range []string{"lack", "lucy", "bob", "l_x"} {
+		assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString(sub, "user", "read")))
+		assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString(sub, "user", "write")))
+	}
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "dev"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "lucy", Group: "dev"}))
+
+	policies := make([]*api.Policy, 0)
+	token := ""
+	for {
+		page, next, err := r.ListPolicies(ctx, PolicyFilter{SubPrefix: "l"}, 2, token)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.LessOrEqual(t, len(page), 2)
+		policies = append(policies, page...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Len(t, policies, 6)
+
+	policies, next, err := r.ListPolicies(ctx, PolicyFilter{SubPrefix: "l_", Act: "write"}, 0, "")
+	assert.NoError(t, err)
+	assert.Empty(t, next)
+	assert.Equal(t, []*api.Policy{newPolicy("l_x", "user", "write")}, policies)
+
+	_, _, err = r.ListPolicies(ctx, PolicyFilter{}, 0, "token")
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	subjects, _, err := r.ListSubjects(ctx, SubjectFilter{Group: "dev"}, 0, "")
+	assert.NoError(t, err)
+	assert.Len(t, subjects, 2)
+
+	subjects, _, err = r.ListSubjects(ctx, SubjectFilter{Ptype: api.PType_GROUP}, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []*api.Subject{{Ptype: api.PType_GROUP, User: "lucy", Group: "dev"}}, subjects)
+}
+
+func TestGetSubjectsForEndpoint(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "user", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("bob", "user", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("bob", "user", "write")))
+	for _, sub := range []*api.Subject{
+		{Ptype: api.PType_ROLE, User: "lack", Group: "reader"},
+		{Ptype: api.PType_GROUP, User: "lack", Group: "reader"},
+		{Ptype: api.PType_ROLE, User: "lucy", Group: "lack"},
+		{Ptype: api.PType_GROUP, User: "lucy", Group: "lack"},
+		// a g rule without the matching g2 rule grants nothing
+		{Ptype: api.PType_ROLE, User: "tom", Group: "reader"},
+	} {
+		assert.NoError(t, r.AddGroupPolicy(ctx, sub))
+	}
+
+	subjects, err := r.GetSubjectsForEndpoint(ctx, &vapi.Endpoint{Entity: "user", Method: []string{"read"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"bob", "reader"}, subjects.Direct)
+	assert.Equal(t, []string{"lack", "lucy"}, subjects.Inherited)
+	assert.Equal(t, []string{"administrator", "root", DefaultAdminName}, subjects.SuperUsers)
+
+	for _, sub := range subjects.Inherited {
+		ok, err := r.Enforce(ctx, api.NewPolicyWithString(sub, "user", "read"))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, err := r.Enforce(ctx, api.NewPolicyWithString("tom", "user", "read"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// mutatingWriter adds a policy on every write.
+type mutatingWriter struct {
+	r     RBAC
+	added int
+}
+
+func (w *mutatingWriter) Write(p []byte) (int, error) {
+	w.added++
+	done := make(chan error, 1)
+	go func() {
+		done <- w.r.AddPolicy(context.TODO(), api.NewPolicyWithString(fmt.Sprintf("writer%d", w.added), "user", "read"))
+	}()
+	select {
+	case err := <-done:
+		return len(p), err
+	case <-time.After(time.Second):
+		return 0, errors.New("the lock is held while writing")
+	}
+}
+
+func TestExportMatrixUnlocked(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	for _, sub := range []string{"alice", "bob"} {
+		assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString(sub, "user", "read")))
+	}
+
+	// the rows written are those of the rules when the export started
+	w := &mutatingWriter{r: r}
+	assert.NoError(t, r.ExportMatrix(ctx, w, api.MatrixFormat_JSON, MatrixScope{}))
+	assert.Equal(t, 2, w.added)
+}
+
+func TestExportMatrix(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "user", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "order", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("lack", "user", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("lack", "user", "write")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "lack", Group: "reader"}))
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, r.ExportMatrix(ctx, buf, api.MatrixFormat_CSV, MatrixScope{Subjects: []string{"lack"}}))
+	assert.Equal(t, "subject,object,action,via\n"+
+		"lack,order,read,reader\n"+
+		"lack,user,read,lack\n"+
+		"lack,user,write,lack\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, r.ExportMatrix(ctx, buf, api.MatrixFormat_JSON, MatrixScope{ObjPrefix: "ord"}))
+	assert.Equal(t, `{"subject":"lack","object":"order","action":"read","via":"reader"}`+"\n"+
+		`{"subject":"reader","object":"order","action":"read","via":"reader"}`+"\n", buf.String())
+}
+
+func TestImportExport(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	f, err := os.Open("examples/rbac_policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("lack", "user", "read")))
+
+	imported, err := r.Import(ctx, f, api.ImportMode_MERGE)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &Imported{Added: 5}, imported)
+	assert.Len(t, r.GetPolicies(ctx, "lack"), 1)
+
+	_, err = r.Import(ctx, strings.NewReader("p, bob, data3, read\np, bob, data3\n"), api.ImportMode_MERGE)
+	assert.ErrorIs(t, err, ErrInvalidPolicyFile)
+	assert.Len(t, r.GetPolicies(ctx, "bob"), 1)
+
+	file := "# replaces everything\n" +
+		"p, bob, data2, write\n" +
+		"p, bob, data3, \"read,write\"\n" +
+		"g2, alice, data2_admin\n"
+	imported, err = r.Import(ctx, strings.NewReader(file), api.ImportMode_REPLACE)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &Imported{Added: 2, Removed: 5}, imported)
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, r.Export(ctx, buf))
+	assert.Equal(t, "p, bob, data2, write\n"+
+		"p, bob, data3, \"read,write\"\n"+
+		"g2, alice, data2_admin\n", buf.String())
+
+	ok, err := r.Enforce(ctx, &api.Policy{Sub: "bob", Endpoint: &vapi.Endpoint{Entity: "data3", Method: []string{"read", "write"}}})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestReconcile(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "user", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "user", "delete")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}))
+
+	doc, err := ParseDocument([]byte(`
+roles:
+  - name: reader
+    endpoints:
+      - entity: user
+        method: [read]
+      - entity: order
+        method: [read]
+memberships:
+  - user: lack
+    roles: [reader]
+    groups: [reader]
+superUsers: [root]
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	diff, err := r.Reconcile(ctx, doc, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "- p, reader, user, delete\n"+
+		"+ p, reader, order, read\n"+
+		"+ g2, lack, reader\n", diff.String())
+	assert.Len(t, r.GetPolicies(ctx, "reader"), 2)
+
+	_, err = r.Reconcile(ctx, doc, false)
+	assert.NoError(t, err)
+	ok, err := r.Enforce(ctx, api.NewPolicyWithString("lack", "order", "read"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = r.Enforce(ctx, api.NewPolicyWithString("lack", "user", "delete"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	diff, err = r.Reconcile(ctx, doc, false)
+	assert.NoError(t, err)
+	assert.True(t, diff.Empty())
+
+	doc, err = ParseDocument([]byte(`{"superUsers": ["lack"]}`))
+	assert.NoError(t, err)
+	_, err = r.Reconcile(ctx, doc, true)
+	assert.ErrorIs(t, err, ErrInvalidDocument)
+}
+
+func TestPlan(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("writer", "data2", "write")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("bob", "data2", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "writer"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "bob", Group: "writer"}))
+
+	impact, err := r.Plan(ctx, []*Change{
+		{Remove: true, Subject: &api.Subject{Ptype: api.PType_GROUP, User: "bob", Group: "writer"}},
+		{Policy: api.NewPolicyWithString("writer", "data3", "write")},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "bob loses write on data2\n"+
+		"writer gains write on data3\n", impact.String())
+
+	// nothing is persisted
+	ok, err := r.Enforce(ctx, api.NewPolicyWithString("bob", "data2", "write"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, r.GetPolicies(ctx, "writer"), 1)
+
+	_, err = r.Plan(ctx, []*Change{{}})
+	assert.Error(t, err)
+}
+
+func TestVersions(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "user", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "order", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "reader"}))
+
+	// a bad bulk edit
+	_, err := r.DeleteRole(ctx, "reader")
+	assert.NoError(t, err)
+
+	versions, next, err := r.ListVersions(ctx, 3, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, versions, 3)
+	assert.Equal(t, "3", next)
+	versions, next, err = r.ListVersions(ctx, 3, next)
+	assert.NoError(t, err)
+	assert.Empty(t, next)
+	if assert.Len(t, versions, 1) {
+		assert.Equal(t, int64(4), versions[0].ID)
+		assert.Len(t, versions[0].Diff.RemovedPolicies, 2)
+		assert.Len(t, versions[0].Diff.RemovedSubjects, 1)
+	}
+
+	policies, subjects, err := r.GetPolicyAt(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+	assert.Len(t, subjects, 0)
+
+	diff, err := r.DiffVersions(ctx, 1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "+ p, reader, order, read\n+ g, lack, reader\n", diff.String())
+
+	diff, err = r.Rollback(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, diff.AddedPolicies, 2)
+	assert.Len(t, r.GetPolicies(ctx, "reader"), 2)
+	assert.Len(t, r.GetGroupPolicies(ctx, api.PType_ROLE, "lack"), 1)
+
+	versions, _, err = r.ListVersions(ctx, 0, "4")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	_, err = r.Rollback(ctx, 10)
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+func TestConcurrentUse(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sqlite doesn't allow concurrent writers
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewConfig(apt, WithDecisionCache(DecisionCacheConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the goroutines change the rules of each other, so only the errors
+	// which the conflicts can't explain are reported
+	check := func(err error) {
+		if err != nil && !errors.Is(err, ErrAlreadyExists) && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrVersionNotFound) {
+			t.Error(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx := context.TODO()
+			user, role := fmt.Sprintf("user%d", i), fmt.Sprintf("role%d", i)
+			endpoint := &vapi.Endpoint{Entity: fmt.Sprintf("data%d", i), Method: []string{"read"}}
+			for n := 0; n < 10; n++ {
+				check(r.AddPolicy(ctx, &api.Policy{Sub: role, Endpoint: endpoint}))
+				check(r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: user, Group: role}))
+				check(r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: user, Group: role}))
+
+				_, err := r.Enforce(ctx, &api.Policy{Sub: user, Endpoint: endpoint})
+				check(err)
+				r.GetAllPolicies(ctx)
+				r.GetPolicies(ctx, role)
+				r.GetGroupPolicies(ctx, api.PType_ROLE, user)
+				_, _, err = r.ListPolicies(ctx, PolicyFilter{SubPrefix: "role"}, 5, "")
+				check(err)
+				_, _, err = r.ListSubjects(ctx, SubjectFilter{UserPrefix: "user"}, 5, "")
+				check(err)
+				_, err = r.GetSubjectsForEndpoint(ctx, endpoint)
+				check(err)
+				check(r.ExportMatrix(ctx, io.Discard, api.MatrixFormat_JSON, MatrixScope{}))
+				check(r.Export(ctx, io.Discard))
+				_, err = r.Plan(ctx, []*Change{{Remove: true, Subject: &api.Subject{Ptype: api.PType_ROLE, User: user, Group: role}}})
+				check(err)
+				_, _, err = r.ListVersions(ctx, 5, "")
+				check(err)
+				_, _, err = r.GetPolicyAt(ctx, 1)
+				check(err)
+				_, err = r.DiffVersions(ctx, 0, 1)
+				check(err)
+				if _, err = r.QueryAuditLog(ctx, AuditQuery{}); !errors.Is(err, ErrAuditDisabled) {
+					t.Error(err)
+				}
+
+				check(r.RenameSubject(ctx, user, user+"-renamed", true))
+				check(r.DelGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: user + "-renamed", Group: role}))
+				_, err = r.DeleteSubject(ctx, user+"-renamed")
+				check(err)
+				check(r.DelPolicy(ctx, &api.Policy{Sub: role, Endpoint: endpoint}))
+				_, err = r.Import(ctx, strings.NewReader(fmt.Sprintf("p, %s, data%d, read\n", role, i)), api.ImportMode_MERGE)
+				check(err)
+				_, err = r.DeleteRole(ctx, role)
+				check(err)
+			}
+
+			switch i {
+			case 0:
+				_, err := r.Reconcile(ctx, &Document{}, false)
+				check(err)
+			case 1:
+				_, err := r.Rollback(ctx, 1)
+				check(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+type testWatcher struct {
+	sync.Mutex
+	callback func(string)
+	updates  int
+}
+
+func (w *testWatcher) SetUpdateCallback(callback func(string)) error {
+	w.Lock()
+	defer w.Unlock()
+	w.callback = callback
+	return nil
+}
+
+func (w *testWatcher) Update() error {
+	w.Lock()
+	defer w.Unlock()
+	w.updates++
+	return nil
+}
+
+func (w *testWatcher) Close() {}
+
+// blockingLogger records the decisions once it is released.
+type blockingLogger struct {
+	mu        sync.Mutex
+	release   chan struct{}
+	decisions []*Decision
+}
+
+func (l *blockingLogger) LogDecisions(ctx context.Context, decisions []*Decision) error {
+	<-l.release
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decisions = append(l.decisions, decisions...)
+	return nil
+}
+
+func TestDecisionLogFullQueue(t *testing.T) {
+	logger := &blockingLogger{release: make(chan struct{})}
+	l := newDecisionLog(DecisionLogConfig{Logger: logger, SampleRate: 1, BatchSize: 1, DenyTimeout: time.Second})
+
+	// the first decision blocks the logger, the next ones fill the queue
+	l.log(&Decision{Subject: "alice", Allowed: true})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(l.queue) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the logger didn't take the first decision")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < cap(l.queue); i++ {
+		l.log(&Decision{Subject: "alice", Allowed: true})
+	}
+
+	// an allowed decision is dropped, a denied one waits for a while
+	l.log(&Decision{Subject: "bob", Allowed: true})
+	start := time.Now()
+	l.log(&Decision{Subject: "eve", Allowed: false})
+	assert.GreaterOrEqual(t, time.Since(start), l.DenyTimeout)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&l.dropped))
+
+	denied := make(chan struct{})
+	go func() {
+		l.log(&Decision{Subject: "mallory", Allowed: false})
+		close(denied)
+	}()
+	select {
+	case <-denied:
+		t.Fatal("a denied decision was dropped")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(logger.release)
+	<-denied
+	assert.NoError(t, l.close())
+
+	subjects := map[string]int{}
+	for _, d := range logger.decisions {
+		subjects[d.Subject]++
+	}
+	assert.Equal(t, map[string]int{"alice": cap(l.queue) + 1, "mallory": 1}, subjects)
+}
+
+func TestDecisionCache(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := &testWatcher{}
+	cfg, err := NewConfig(apt, WithDecisionCache(DecisionCacheConfig{Size: 2, TTL: time.Hour}), WithWatcher(watcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx := context.TODO()
+	enforce := func(sub, obj, act string) bool {
+		ok, err := r.Enforce(ctx, api.NewPolicyWithString(sub, obj, act))
+		assert.NoError(t, err)
+		return ok
+	}
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "data1", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "reader"}))
+	assert.False(t, enforce("bob", "data1", "read"))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "bob", Group: "reader"}))
+	assert.True(t, enforce("bob", "data1", "read"))
+
+	// the policies of a role are inherited by its users
+	assert.NoError(t, r.DelPolicy(ctx, api.NewPolicyWithString("reader", "data1", "read")))
+	assert.False(t, enforce("bob", "data1", "read"))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "data1", "read")))
+	assert.True(t, enforce("bob", "data1", "read"))
+	assert.NoError(t, r.DelGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "reader"}))
+	assert.False(t, enforce("bob", "data1", "read"))
+
+	watcher.Lock()
+	assert.Equal(t, 6, watcher.updates)
+	callback := watcher.callback
+	watcher.Unlock()
+
+	// a change made by another replica is seen once the watcher reports it
+	assert.True(t, enforce("reader", "data1", "read"))
+	assert.NoError(t, apt.RemovePolicy("p", "p", []string{"reader", "data1", "read"}))
+	assert.True(t, enforce("reader", "data1", "read"))
+	callback("")
+	assert.Eventually(t, func() bool { return !enforce("reader", "data1", "read") }, time.Second, 10*time.Millisecond)
+}
+
+func TestDecisionCacheEviction(t *testing.T) {
+	c := newDecisionCache(DecisionCacheConfig{Size: 2, TTL: 50 * time.Millisecond})
+
+	c.put(cacheKey{"alice", "data1", "read"}, true, nil)
+	c.put(cacheKey{"bob", "data1", "read"}, false, nil)
+	_, ok := c.get(cacheKey{"alice", "data1", "read"})
+	assert.True(t, ok)
+
+	// bob is the least recently used
+	c.put(cacheKey{"alice", "data2", "read"}, true, nil)
+	_, ok = c.get(cacheKey{"bob", "data1", "read"})
+	assert.False(t, ok)
+
+	c.invalidate("alice", "data2", "read")
+	_, ok = c.get(cacheKey{"alice", "data2", "read"})
+	assert.False(t, ok)
+	_, ok = c.get(cacheKey{"alice", "data1", "read"})
+	assert.True(t, ok)
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = c.get(cacheKey{"alice", "data1", "read"})
+	assert.False(t, ok)
+	assert.Empty(t, c.subjects)
+}
+
+func TestGetImplicitPermissions(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("reader", "data1", "read")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("writer", "data1", "write")))
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("bob", "data2", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "writer", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "writer", Group: "reader"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "bob", Group: "writer"}))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "bob", Group: "writer"}))
+
+	assert.Equal(t, []*Permission{
+		{Subject: "bob", Object: "data1", Action: "read", Via: "reader"},
+		{Subject: "bob", Object: "data1", Action: "write", Via: "writer"},
+		{Subject: "bob", Object: "data2", Action: "read", Via: "bob"},
+	}, r.GetImplicitPermissions(ctx, "bob"))
+
+	assert.NoError(t, r.DelGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "writer", Group: "reader"}))
+	assert.Len(t, r.GetImplicitPermissions(ctx, "bob"), 2)
+	_, err := r.DeleteSubject(ctx, "bob")
+	assert.NoError(t, err)
+	assert.Empty(t, r.GetImplicitPermissions(ctx, "bob"))
+}
+
+// TestPermissionIndex checks the index against the matcher of casbin after
+// every kind of mutation.
+func TestPermissionIndex(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	doc, err := ParseDocument([]byte(`
+roles:
+  - name: reader
+    endpoints:
+      - entity: data1
+        method: [read]
+  - name: writer
+    endpoints:
+      - entity: data1
+        method: [write]
+      - entity: data2
+        method: [write]
+memberships:
+  - user: writer
+    roles: [reader]
+    groups: [reader]
+  - user: alice
+    roles: [writer]
+    groups: [writer]
+  - user: bob
+    roles: [reader]
+    groups: [writer]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subjects := []string{"reader", "writer", "alice", "bob", "carol", "root"}
+	check := func() {
+		x := r.(*rbac)
+		for _, sub := range subjects {
+			for _, obj := range []string{"data1", "data2"} {
+				for _, act := range []string{"read", "write"} {
+					want, err := x.e.Enforce(sub, obj, act)
+					assert.NoError(t, err)
+					got, _ := x.enforce(obj, act, sub)
+					assert.Equal(t, want, got, "%s %s %s", sub, obj, act)
+				}
+			}
+		}
+	}
+
+	_, err = r.Reconcile(ctx, doc, false)
+	assert.NoError(t, err)
+	check()
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_GROUP, User: "bob", Group: "reader"}))
+	check()
+	assert.NoError(t, r.RenameSubject(ctx, "writer", "editor", false))
+	check()
+	assert.NoError(t, r.DelPolicy(ctx, api.NewPolicyWithString("reader", "data1", "read")))
+	check()
+	_, err = r.DeleteRole(ctx, "editor")
+	assert.NoError(t, err)
+	check()
+	_, err = r.Rollback(ctx, 1)
+	assert.NoError(t, err)
+	check()
+}
+
+func TestPermissionIndexDepth(t *testing.T) {
+	r := newTestRBAC(t)
+	ctx := context.TODO()
+
+	// user0 is linked to user15 by 15 roles and groups, the matcher follows
+	// maxHierarchyLevel of them
+	const depth = 15
+	for i := 0; i < depth; i++ {
+		user, group := fmt.Sprintf("user%d", i), fmt.Sprintf("user%d", i+1)
+		for _, ptype := range []api.PType{api.PType_ROLE, api.PType_GROUP} {
+			assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: ptype, User: user, Group: group}))
+		}
+	}
+
+	x := r.(*rbac)
+	check := func() {
+		for i := 0; i <= depth; i++ {
+			sub := fmt.Sprintf("user%d", i)
+			want, err := x.e.Enforce(sub, "data1", "read")
+			assert.NoError(t, err)
+			got, _ := x.enforce("data1", "read", sub)
+			assert.Equal(t, want, got, sub)
+		}
+	}
+
+	// the index is updated by the policy, then built from the rules
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString(fmt.Sprintf("user%d", depth), "data1", "read")))
+	check()
+	x.buildIndex()
+	check()
+	got, _ := x.enforce("data1", "read", "user0")
+	assert.False(t, got)
+}
+
+func TestWatch(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := &testWatcher{}
+	cfg, err := NewConfig(apt, WithWatcher(watcher), WithWatchHistory(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	events, err := r.Watch(ctx, "", 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	next := func() *WatchEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return nil
+		}
+	}
+	first := next()
+	assert.Equal(t, int64(0), first.Revision)
+	assert.Len(t, first.Events, 0)
+	epoch := first.Epoch
+	assert.NotEmpty(t, epoch)
+
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("alice", "data1", "read")))
+	ev := next()
+	assert.Equal(t, int64(1), ev.Revision)
+	assert.Equal(t, epoch, ev.Epoch)
+	if assert.Len(t, ev.Events, 1) {
+		assert.Equal(t, api.EventType_ADDED, ev.Events[0].Type)
+		assert.Equal(t, "alice", ev.Events[0].Policy.Sub)
+	}
+
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "alice", Group: "admin"}))
+	ev = next()
+	assert.Equal(t, int64(2), ev.Revision)
+	if assert.Len(t, ev.Events, 1) {
+		assert.Equal(t, api.EventType_ADDED, ev.Events[0].Type)
+		assert.Equal(t, "admin", ev.Events[0].Subject.Group)
+	}
+
+	// the rules of a renamed subject are updated
+	assert.NoError(t, r.RenameSubject(ctx, "alice", "bob", false))
+	ev = next()
+	assert.Equal(t, int64(3), ev.Revision)
+	if assert.Len(t, ev.Events, 2) {
+		for _, e := range ev.Events {
+			assert.Equal(t, api.EventType_UPDATED, e.Type)
+		}
+		assert.Equal(t, "bob", ev.Events[0].Policy.Sub)
+		assert.Equal(t, "alice", ev.Events[0].PreviousPolicy.Sub)
+		assert.Equal(t, "bob", ev.Events[1].Subject.User)
+		assert.Equal(t, "alice", ev.Events[1].PreviousSubject.User)
+	}
+
+	// a change made by another replica is sent once the watcher reports it
+	assert.NoError(t, apt.RemovePolicy("p", "p", []string{"bob", "data1", "read"}))
+	watcher.Lock()
+	callback := watcher.callback
+	watcher.Unlock()
+	callback("")
+	ev = next()
+	assert.Equal(t, int64(4), ev.Revision)
+	if assert.Len(t, ev.Events, 1) {
+		assert.Equal(t, api.EventType_REMOVED, ev.Events[0].Type)
+		assert.Equal(t, "bob", ev.Events[0].Policy.Sub)
+	}
+
+	// a watch resumes after a revision of the history
+	resumed, err := r.Watch(ctx, epoch, 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(2), (<-resumed).Revision)
+		assert.Equal(t, int64(3), (<-resumed).Revision)
+		assert.Equal(t, int64(4), (<-resumed).Revision)
+	}
+	_, err = r.Watch(ctx, epoch, 1)
+	assert.ErrorIs(t, err, ErrRevisionCompacted)
+	_, err = r.Watch(ctx, epoch, 5)
+	assert.ErrorIs(t, err, ErrRevisionCompacted)
+	// a revision numbered by another process is not resumed
+	_, err = r.Watch(ctx, "", 2)
+	assert.ErrorIs(t, err, ErrRevisionCompacted)
+	_, err = r.Watch(ctx, "0123456789abcdef", 2)
+	assert.ErrorIs(t, err, ErrRevisionCompacted)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, ok := <-events
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestOnChangePanic(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the listener after a panicking one gets every event
+	changed := make(chan *ChangeEvent, 2)
+	cfg, err := NewConfig(apt, OnChange(
+		func(ev *ChangeEvent) { panic("listener failure") },
+		func(ev *ChangeEvent) { changed <- ev },
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx := context.TODO()
+	for _, sub := range []string{"alice", "bob"} {
+		assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString(sub, "data1", "read")))
+	}
+	for _, sub := range []string{"alice", "bob"} {
+		select {
+		case ev := <-changed:
+			if assert.Len(t, ev.After.Policies, 1) {
+				assert.Equal(t, sub, ev.After.Policies[0].Sub)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		r      RBAC
+		mu     sync.Mutex
+		events []*ChangeEvent
+	)
+	watcher := &testWatcher{}
+	cfg, err := NewConfig(apt, WithWatcher(watcher), OnChange(func(ev *ChangeEvent) {
+		// the listeners may call RBAC
+		_ = r.GetPolicies(context.TODO(), "bob")
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithReason(metadata.Set(context.TODO(), ActorKey, "admin"), "onboarding")
+	assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString("alice", "data1", "read")))
+	assert.NoError(t, r.AddGroupPolicy(ctx, &api.Subject{Ptype: api.PType_ROLE, User: "alice", Group: "reader"}))
+	assert.NoError(t, r.RenameSubject(ctx, "alice", "bob", false))
+	// a failed mutation is not a change
+	assert.ErrorIs(t, r.DelPolicy(ctx, api.NewPolicyWithString("alice", "data1", "read")), ErrNotFound)
+
+	assert.NoError(t, apt.RemovePolicy("p", "p", []string{"bob", "data1", "read"}))
+	watcher.Lock()
+	callback := watcher.callback
+	watcher.Unlock()
+	callback("")
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) == 4
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, r.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !assert.Len(t, events, 4) {
+		return
+	}
+
+	ev := events[0]
+	assert.Equal(t, "AddPolicy", ev.Operation)
+	assert.Equal(t, "admin", ev.Actor)
+	assert.Equal(t, "onboarding", ev.Reason)
+	assert.Equal(t, int64(1), ev.Revision)
+	assert.Empty(t, ev.Before.Policies)
+	if assert.Len(t, ev.After.Policies, 1) {
+		assert.Equal(t, "alice", ev.After.Policies[0].Sub)
+	}
+
+	ev = events[1]
+	assert.Equal(t, "AddGroupPolicy", ev.Operation)
+	if assert.Len(t, ev.After.Subjects, 1) {
+		assert.Equal(t, "reader", ev.After.Subjects[0].Group)
+	}
+
+	ev = events[2]
+	assert.Equal(t, "RenameSubject", ev.Operation)
+	assert.Len(t, ev.Before.Policies, 1)
+	assert.Len(t, ev.Before.Subjects, 1)
+	assert.Len(t, ev.After.Policies, 1)
+	assert.Len(t, ev.After.Subjects, 1)
+	assert.Equal(t, "bob", ev.After.Policies[0].Sub)
+
+	ev = events[3]
+	assert.Equal(t, "Reload", ev.Operation)
+	assert.Empty(t, ev.Actor)
+	assert.Equal(t, int64(4), ev.Revision)
+	if assert.Len(t, ev.Before.Policies, 1) {
+		assert.Equal(t, "bob", ev.Before.Policies[0].Sub)
+	}
+}
+
+func BenchmarkEnforce(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(b.TempDir(), dsn)), &gorm.Config{CreateBatchSize: 1000})
+		if err != nil {
+			b.Fatal(err)
+		}
+		apt, err := adapter.NewGormAdapter(db)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cfg, err := NewConfig(apt)
+		if err != nil {
+			b.Fatal(err)
+		}
+		r, err := NewRBAC(cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		// ten policies for each role, and a user for every role
+		var sb strings.Builder
+		for i := 0; i < n; i++ {
+			sb.WriteString(fmt.Sprintf("p, role%d, data%d, read\n", i/10, i))
+		}
+		for i := 0; i < n/10; i++ {
+			sb.WriteString(fmt.Sprintf("g, user%d, role%d\ng2, user%d, role%d\n", i, i, i, i))
+		}
+		if _, err = r.Import(context.TODO(), strings.NewReader(sb.String()), api.ImportMode_MERGE); err != nil {
+			b.Fatal(err)
+		}
+
+		p := api.NewPolicyWithString("user0", "data5", "read")
+		b.Run(fmt.Sprintf("index/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if ok, _ := r.Enforce(context.TODO(), p); !ok {
+					b.Fatal("denied")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("matcher/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if ok, _ := r.(*rbac).e.Enforce("user0", "data5", "read"); !ok {
+					b.Fatal("denied")
+				}
+			}
+		})
+	}
+}
+
+func TestImportSharedStorage(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two replicas share the rules, and the second one doesn't see the
+	// changes of the first one
+	replicas := make([]RBAC, 0, 2)
+	for i := 0; i < 2; i++ {
+		apt, err := adapter.NewGormAdapter(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := NewConfig(apt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewRBAC(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		replicas = append(replicas, r)
+	}
+
+	ctx := context.TODO()
+	assert.NoError(t, replicas[0].AddPolicy(ctx, api.NewPolicyWithString("alice", "data1", "read")))
+
+	imported, err := replicas[1].Import(ctx, strings.NewReader("p, alice, data1, read\np, bob, data1, read\n"), api.ImportMode_MERGE)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &Imported{Added: 1}, imported)
+	assert.Len(t, replicas[1].GetPolicies(ctx, "alice"), 1)
+
+	// a rule already stored by the other replica is added without error
+	assert.NoError(t, replicas[0].AddPolicy(ctx, api.NewPolicyWithString("bob", "data1", "read")))
+
+	var count int64
+	assert.NoError(t, db.Table("rbac_rule").Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+}
+
+// unversioned is an adapter which fails to record the versions.
+type unversioned struct {
+	*adapter.GormAdapter
+}
+
+func (a *unversioned) AddVersion(v *adapter.Version) error {
+	return errors.New("unavailable")
+}
+
+func TestCommitVersionFailure(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewConfig(&unversioned{GormAdapter: apt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx := context.TODO()
+	events, err := r.Watch(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-events
+
+	// the change is reverted, so that it can be retried
+	p := api.NewPolicyWithString("alice", "data1", "read")
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, r.AddPolicy(ctx, p), ErrCasbin)
+	}
+	assert.Len(t, r.GetPolicies(ctx, "alice"), 0)
+	ok, err := r.Enforce(ctx, p)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	rules, _, err := apt.ListRules(adapter.Query{})
+	assert.NoError(t, err)
+	assert.Len(t, rules, 0)
+
+	select {
+	case ev := <-events:
+		t.Fatalf("Watch() sent %v for a reverted change", ev)
+	default:
+	}
+}
+
+// plainAdapter hides the extensions of the adapter, such as
+// adapter.VersionStore.
+type plainAdapter struct {
+	persist.Adapter
+}
+
+func TestMemoryVersions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewConfig(&plainAdapter{Adapter: apt}, WithVersionHistory(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx := context.TODO()
+	for _, sub := range []string{"alice", "bob", "carol"} {
+		assert.NoError(t, r.AddPolicy(ctx, api.NewPolicyWithString(sub, "data1", "read")))
+	}
+
+	// only the latest versions are kept
+	versions, _, err := r.ListVersions(ctx, 0, "")
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, int64(2), versions[0].ID)
+		assert.Equal(t, int64(3), versions[1].ID)
+	}
+	_, _, err = r.GetPolicyAt(ctx, 1)
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+	policies, _, err := r.GetPolicyAt(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+}
+
+// failingSink is an audit sink which fails to write the records.
+type failingSink struct{}
+
+func (failingSink) Write(ctx context.Context, records ...*AuditRecord) error {
+	return errors.New("unavailable")
+}
+
+func (failingSink) Query(ctx context.Context, query AuditQuery) ([]*AuditRecord, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestCommitAuditFailure(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), dsn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := make(chan *ChangeEvent, 1)
+	cfg, err := NewConfig(apt, WithAuditSink(failingSink{}), OnChange(func(ev *ChangeEvent) { changed <- ev }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the committed change is kept when the audit log fails
+	ctx := context.TODO()
+	p := api.NewPolicyWithString("alice", "data1", "read")
+	assert.NoError(t, r.AddPolicy(ctx, p))
+	ok, err := r.Enforce(ctx, p)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	versions, _, err := r.ListVersions(ctx, 0, "")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.Equal(t, "AddPolicy", (<-changed).Operation)
+}
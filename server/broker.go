@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/vine/core/broker"
+	log "github.com/vine-io/vine/lib/logger"
+)
+
+// PublishChanges publishes every change of the rules on the topic of the
+// broker as an api.PolicyChange, api.ChangeTopic by default. The changes are
+// published in the background, in order; a change which fails to be
+// published is logged and dropped. The changes loaded from another replica
+// are skipped, that replica publishes them.
+func PublishChanges(b broker.Broker, topic string) rbac.Option {
+	if topic == "" {
+		topic = api.ChangeTopic
+	}
+
+	return rbac.OnChange(func(ev *rbac.ChangeEvent) {
+		if ev.Operation == rbac.ReloadOperation {
+			return
+		}
+
+		change := &api.PolicyChange{
+			Timestamp: ev.Time.Unix(),
+			Actor:     ev.Actor,
+			Operation: ev.Operation,
+			Reason:    ev.Reason,
+			Revision:  ev.Revision,
+			Epoch:     ev.Epoch,
+			Diff: &api.Diff{
+				AddedPolicies:   ev.After.Policies,
+				RemovedPolicies: ev.Before.Policies,
+				AddedSubjects:   ev.After.Subjects,
+				RemovedSubjects: ev.Before.Subjects,
+			},
+		}
+		body, err := change.Marshal()
+		if err != nil {
+			log.Errorf("marshal the change of revision %d: %v", ev.Revision, err)
+			return
+		}
+
+		err = b.Publish(context.Background(), topic, &broker.Message{
+			Header: map[string]string{"Content-Type": "application/protobuf"},
+			Body:   body,
+		})
+		if err != nil {
+			log.Errorf("publish the change of revision %d on %s: %v", ev.Revision, topic, err)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/adapter"
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/vine/core/broker"
+	"github.com/vine-io/vine/core/broker/memory"
+)
+
+func TestPublishChangesSkipsReload(t *testing.T) {
+	ctx := context.TODO()
+	b := memory.NewBroker()
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Disconnect()
+
+	operations := make(chan string, 10)
+	sub, err := b.Subscribe(api.ChangeTopic, func(ev broker.Event) error {
+		change := &api.PolicyChange{}
+		if err := change.Unmarshal(ev.Message().Body); err != nil {
+			return err
+		}
+		operations <- change.Operation
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	watcher := &reloadWatcher{}
+	server, db := newWebhookServer(t, PublishChanges(b, ""), rbac.WithWatcher(watcher))
+
+	// another replica adds a rule, which that replica publishes
+	other, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = other.AddPolicy("p", "p", []string{"bob", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+	watcher.callback("")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rsp := &api.GetPoliciesResponse{}
+		if err = server.GetPolicies(ctx, &api.GetPoliciesRequest{Sub: "bob"}, rsp); err != nil {
+			t.Fatal(err)
+		}
+		if len(rsp.Policies) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the rules weren't reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	policy := api.NewPolicyWithString("alice", "data1", "read")
+	if err = server.AddPolicy(ctx, &api.AddPolicyRequest{Policy: policy}, &api.AddPolicyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the changes are published in order
+	select {
+	case operation := <-operations:
+		if operation != "AddPolicy" {
+			t.Fatalf("published %s, want AddPolicy", operation)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddPolicy wasn't published")
+	}
+}
@@ -1,7 +1,11 @@
 package server
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"io"
+	"time"
 
 	"github.com/casbin/casbin/v2/persist"
 	"github.com/vine-io/rbac"
@@ -13,10 +17,12 @@ import (
 type RBACServer struct {
 	vine.Service
 	r rbac.RBAC
+	// webhooks is set by EnableWebhooks
+	webhooks *WebhookDispatcher
 }
 
-func NewRBACServerWithApt(s vine.Service, apt persist.Adapter) (*RBACServer, error) {
-	cfg, err := rbac.NewConfig(apt)
+func NewRBACServerWithApt(s vine.Service, apt persist.Adapter, opts ...rbac.Option) (*RBACServer, error) {
+	cfg, err := rbac.NewConfig(apt, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +57,7 @@ func (s *RBACServer) AddPolicy(ctx context.Context, req *api.AddPolicyRequest, r
 		return verrs.BadRequest(s.Name(), "missing policy")
 	}
 
-	err = s.r.AddPolicy(ctx, req.Policy)
+	err = s.r.AddPolicy(rbac.WithReason(ctx, req.Reason), req.Policy)
 	return
 }
 
@@ -60,7 +66,7 @@ func (s *RBACServer) DelPolicy(ctx context.Context, req *api.DelPolicyRequest, r
 		return verrs.BadRequest(s.Name(), "missing policy")
 	}
 
-	err = s.r.DelPolicy(ctx, req.Policy)
+	err = s.r.DelPolicy(rbac.WithReason(ctx, req.Reason), req.Policy)
 	return
 }
 
@@ -74,7 +80,7 @@ func (s *RBACServer) AddGroupPolicy(ctx context.Context, req *api.AddGroupPolicy
 		return verrs.BadRequest(s.Name(), "missing sub")
 	}
 
-	err = s.r.AddGroupPolicy(ctx, req.Subject)
+	err = s.r.AddGroupPolicy(rbac.WithReason(ctx, req.Reason), req.Subject)
 	return
 }
 
@@ -83,7 +89,7 @@ func (s *RBACServer) DelGroupPolicy(ctx context.Context, req *api.DelGroupPolicy
 		return verrs.BadRequest(s.Name(), "missing sub")
 	}
 
-	err = s.r.DelGroupPolicy(ctx, req.Subject)
+	err = s.r.DelGroupPolicy(rbac.WithReason(ctx, req.Reason), req.Subject)
 	return
 }
 
@@ -95,3 +101,316 @@ func (s *RBACServer) Enforce(ctx context.Context, req *api.EnforceRequest, rsp *
 	rsp.Result, err = s.r.Enforce(ctx, req.Policy)
 	return
 }
+
+func (s *RBACServer) DeleteSubject(ctx context.Context, req *api.DeleteSubjectRequest, rsp *api.DeleteSubjectResponse) (err error) {
+	if req.Sub == "" {
+		return verrs.BadRequest(s.Name(), "missing sub")
+	}
+
+	deleted, err := s.r.DeleteSubject(rbac.WithReason(ctx, req.Reason), req.Sub)
+	if err != nil {
+		return err
+	}
+
+	rsp.Policies = int32(deleted.Policies)
+	rsp.Roles = int32(deleted.Roles)
+	rsp.Groups = int32(deleted.Groups)
+	return
+}
+
+func (s *RBACServer) DeleteRole(ctx context.Context, req *api.DeleteRoleRequest, rsp *api.DeleteRoleResponse) (err error) {
+	if req.Role == "" {
+		return verrs.BadRequest(s.Name(), "missing role")
+	}
+
+	deleted, err := s.r.DeleteRole(rbac.WithReason(ctx, req.Reason), req.Role)
+	if err != nil {
+		return err
+	}
+
+	rsp.Policies = int32(deleted.Policies)
+	rsp.Roles = int32(deleted.Roles)
+	rsp.Groups = int32(deleted.Groups)
+	return
+}
+
+func (s *RBACServer) RenameSubject(ctx context.Context, req *api.RenameSubjectRequest, rsp *api.RenameSubjectResponse) (err error) {
+	if req.OldName == "" || req.NewName == "" {
+		return verrs.BadRequest(s.Name(), "missing name")
+	}
+
+	err = s.r.RenameSubject(rbac.WithReason(ctx, req.Reason), req.OldName, req.NewName, req.Merge)
+	return
+}
+
+func (s *RBACServer) ListPolicies(ctx context.Context, req *api.ListPoliciesRequest, rsp *api.ListPoliciesResponse) (err error) {
+	filter := rbac.PolicyFilter{
+		SubPrefix: req.SubPrefix,
+		Obj:       req.Obj,
+		Act:       req.Act,
+	}
+
+	rsp.Policies, rsp.NextPageToken, err = s.r.ListPolicies(ctx, filter, int(req.PageSize), req.PageToken)
+	if errors.Is(err, rbac.ErrInvalidPageToken) {
+		return verrs.BadRequest(s.Name(), err.Error())
+	}
+	return
+}
+
+func (s *RBACServer) ListSubjects(ctx context.Context, req *api.ListSubjectsRequest, rsp *api.ListSubjectsResponse) (err error) {
+	filter := rbac.SubjectFilter{
+		Ptype:      req.Ptype,
+		UserPrefix: req.UserPrefix,
+		Group:      req.Group,
+	}
+
+	rsp.Subjects, rsp.NextPageToken, err = s.r.ListSubjects(ctx, filter, int(req.PageSize), req.PageToken)
+	if errors.Is(err, rbac.ErrInvalidPageToken) {
+		return verrs.BadRequest(s.Name(), err.Error())
+	}
+	return
+}
+
+func (s *RBACServer) GetSubjectsForEndpoint(ctx context.Context, req *api.GetSubjectsForEndpointRequest, rsp *api.GetSubjectsForEndpointResponse) (err error) {
+	if req.Endpoint == nil {
+		return verrs.BadRequest(s.Name(), "missing endpoint")
+	}
+
+	subjects, err := s.r.GetSubjectsForEndpoint(ctx, req.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	rsp.Direct = subjects.Direct
+	rsp.Inherited = subjects.Inherited
+	rsp.SuperUsers = subjects.SuperUsers
+	return
+}
+
+func (s *RBACServer) ExportMatrix(ctx context.Context, req *api.ExportMatrixRequest, stream api.RBACService_ExportMatrixStream) (err error) {
+	defer stream.Close()
+
+	scope := rbac.MatrixScope{
+		Subjects:  req.Subjects,
+		ObjPrefix: req.ObjPrefix,
+	}
+
+	w := bufio.NewWriterSize(chunkWriter(func(data []byte) error {
+		return stream.Send(&api.ExportMatrixResponse{Data: data})
+	}), chunkSize)
+	if err = s.r.ExportMatrix(ctx, w, req.Format, scope); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// chunkSize is the size of the data sent by each message of the streaming RPCs.
+const chunkSize = 32 * 1024
+
+// chunkWriter sends every write as a message of a stream.
+type chunkWriter func(data []byte) error
+
+func (fn chunkWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	if err := fn(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *RBACServer) ImportPolicies(ctx context.Context, stream api.RBACService_ImportPoliciesStream) (err error) {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	mode, reason := req.Mode, req.Reason
+
+	// feeds the chunks of the stream to Import
+	pr, pw := io.Pipe()
+	go func(req *api.ImportPoliciesRequest) {
+		var e error
+		for e == nil {
+			if _, e = pw.Write(req.Data); e != nil {
+				break
+			}
+			req, e = stream.Recv()
+		}
+		if errors.Is(e, io.EOF) {
+			e = nil
+		}
+		_ = pw.CloseWithError(e)
+	}(req)
+
+	imported, err := s.r.Import(rbac.WithReason(ctx, reason), pr, mode)
+	_ = pr.Close()
+	if errors.Is(err, rbac.ErrInvalidPolicyFile) {
+		return verrs.BadRequest(s.Name(), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&api.ImportPoliciesResponse{
+		Added:   int32(imported.Added),
+		Removed: int32(imported.Removed),
+	})
+}
+
+func (s *RBACServer) ExportPolicies(ctx context.Context, req *api.ExportPoliciesRequest, stream api.RBACService_ExportPoliciesStream) (err error) {
+	defer stream.Close()
+
+	w := bufio.NewWriterSize(chunkWriter(func(data []byte) error {
+		return stream.Send(&api.ExportPoliciesResponse{Data: data})
+	}), chunkSize)
+	if err = s.r.Export(ctx, w); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func (s *RBACServer) Reconcile(ctx context.Context, req *api.ReconcileRequest, rsp *api.ReconcileResponse) (err error) {
+	if len(req.Document) == 0 {
+		return verrs.BadRequest(s.Name(), "missing document")
+	}
+
+	doc, err := rbac.ParseDocument(req.Document)
+	if err != nil {
+		return verrs.BadRequest(s.Name(), err.Error())
+	}
+
+	diff, err := s.r.Reconcile(rbac.WithReason(ctx, req.Reason), doc, req.DryRun)
+	if errors.Is(err, rbac.ErrInvalidDocument) {
+		return verrs.BadRequest(s.Name(), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	rsp.Plan = diff.String()
+	rsp.Added = int32(len(diff.AddedPolicies) + len(diff.AddedSubjects))
+	rsp.Removed = int32(len(diff.RemovedPolicies) + len(diff.RemovedSubjects))
+	return
+}
+
+func (s *RBACServer) ListVersions(ctx context.Context, req *api.ListVersionsRequest, rsp *api.ListVersionsResponse) (err error) {
+	versions, next, err := s.r.ListVersions(ctx, int(req.PageSize), req.PageToken)
+	if errors.Is(err, rbac.ErrInvalidPageToken) {
+		return verrs.BadRequest(s.Name(), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	rsp.Versions = make([]*api.Version, 0, len(versions))
+	for _, v := range versions {
+		rsp.Versions = append(rsp.Versions, &api.Version{
+			Id:        v.ID,
+			Timestamp: v.CreatedAt.Unix(),
+			Diff:      toDiff(v.Diff),
+		})
+	}
+	rsp.NextPageToken = next
+	return
+}
+
+func (s *RBACServer) GetPolicyAt(ctx context.Context, req *api.GetPolicyAtRequest, rsp *api.GetPolicyAtResponse) (err error) {
+	rsp.Policies, rsp.Subjects, err = s.r.GetPolicyAt(ctx, req.Version)
+	if errors.Is(err, rbac.ErrVersionNotFound) {
+		return verrs.NotFound(s.Name(), err.Error())
+	}
+	return
+}
+
+func (s *RBACServer) DiffVersions(ctx context.Context, req *api.DiffVersionsRequest, rsp *api.DiffVersionsResponse) (err error) {
+	diff, err := s.r.DiffVersions(ctx, req.From, req.To)
+	if errors.Is(err, rbac.ErrVersionNotFound) {
+		return verrs.NotFound(s.Name(), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	rsp.Diff = toDiff(diff)
+	return
+}
+
+func (s *RBACServer) Rollback(ctx context.Context, req *api.RollbackRequest, rsp *api.RollbackResponse) (err error) {
+	diff, err := s.r.Rollback(rbac.WithReason(ctx, req.Reason), req.Version)
+	if errors.Is(err, rbac.ErrVersionNotFound) {
+		return verrs.NotFound(s.Name(), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	rsp.Diff = toDiff(diff)
+	return
+}
+
+func toDiff(d *rbac.Diff) *api.Diff {
+	return &api.Diff{
+		AddedPolicies:   d.AddedPolicies,
+		RemovedPolicies: d.RemovedPolicies,
+		AddedSubjects:   d.AddedSubjects,
+		RemovedSubjects: d.RemovedSubjects,
+	}
+}
+
+func (s *RBACServer) QueryAuditLog(ctx context.Context, req *api.QueryAuditLogRequest, rsp *api.QueryAuditLogResponse) (err error) {
+	query := rbac.AuditQuery{
+		Subject: req.Subject,
+		Limit:   int(req.Limit),
+	}
+	if req.Since > 0 {
+		query.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until > 0 {
+		query.Until = time.Unix(req.Until, 0)
+	}
+
+	records, err := s.r.QueryAuditLog(ctx, query)
+	if errors.Is(err, rbac.ErrAuditDisabled) {
+		return verrs.NotImplemented(s.Name(), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	rsp.Records = make([]*api.AuditRecord, 0, len(records))
+	for _, record := range records {
+		rsp.Records = append(rsp.Records, &api.AuditRecord{
+			Timestamp: record.Time.Unix(),
+			Actor:     record.Actor,
+			Operation: record.Operation,
+			Reason:    record.Reason,
+			Before:    record.Before,
+			After:     record.After,
+		})
+	}
+	return
+}
+
+func (s *RBACServer) WatchPolicies(ctx context.Context, req *api.WatchPoliciesRequest, stream api.RBACService_WatchPoliciesStream) (err error) {
+	defer stream.Close()
+
+	events, err := s.r.Watch(ctx, req.Epoch, req.Revision)
+	if errors.Is(err, rbac.ErrRevisionCompacted) {
+		return verrs.Conflict(s.Name(), err.Error())
+	}
+	if errors.Is(err, rbac.ErrClosed) {
+		return verrs.ServiceUnavailable(s.Name(), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	for ev := range events {
+		if err = stream.Send(&api.WatchPoliciesResponse{Revision: ev.Revision, Epoch: ev.Epoch, Events: ev.Events}); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
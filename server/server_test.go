@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"net/http"
 	"os"
 	"testing"
 
@@ -11,6 +12,7 @@ import (
 	vclient "github.com/vine-io/vine/core/client"
 	"github.com/vine-io/vine/core/client/grpc"
 	vapi "github.com/vine-io/vine/lib/api"
+	verrs "github.com/vine-io/vine/lib/errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -179,3 +181,38 @@ func TestRBACServerEtcd_AddPolicy(t *testing.T) {
 
 	testServer(t)
 }
+
+func TestRBACServer_DeleteSubject(t *testing.T) {
+	ctx := context.TODO()
+	server, _ := newWebhookServer(t)
+
+	err := server.DeleteSubject(ctx, &api.DeleteSubjectRequest{}, &api.DeleteSubjectResponse{})
+	if e := verrs.FromErr(err); e == nil || e.Code != http.StatusBadRequest {
+		t.Fatalf("DeleteSubject() = %v, want BadRequest", err)
+	}
+
+	policy := api.NewPolicyWithString("lack", "object", "read")
+	if err = server.AddPolicy(ctx, &api.AddPolicyRequest{Policy: policy}, &api.AddPolicyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	subject := &api.Subject{Ptype: api.PType_ROLE, User: "lack", Group: "admin"}
+	if err = server.AddGroupPolicy(ctx, &api.AddGroupPolicyRequest{Subject: subject}, &api.AddGroupPolicyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rsp := &api.DeleteSubjectResponse{}
+	if err = server.DeleteSubject(ctx, &api.DeleteSubjectRequest{Sub: "lack"}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Policies != 1 || rsp.Roles != 1 || rsp.Groups != 0 {
+		t.Fatalf("DeleteSubject() = %v, want 1 policy and 1 role", rsp)
+	}
+
+	all := &api.GetAllPoliciesResponse{}
+	if err = server.GetAllPolicies(ctx, &api.GetAllPoliciesRequest{}, all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Policies) != 0 || len(all.Subjects) != 0 {
+		t.Fatalf("GetAllPolicies() = %v, %v, want nothing", all.Policies, all.Subjects)
+	}
+}
@@ -0,0 +1,802 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/api"
+	verrs "github.com/vine-io/vine/lib/errors"
+	log "github.com/vine-io/vine/lib/logger"
+)
+
+var (
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrWebhookExists      = errors.New("webhook already exists")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrWebhooksDisabled   = errors.New("webhooks are disabled")
+	ErrInvalidWebhookURL  = errors.New("webhook url must be an absolute http or https url")
+	ErrPrivateWebhookURL  = errors.New("webhook url must not point to a private address")
+	ErrWebhookQueueFull   = errors.New("webhook delivery queue is full")
+)
+
+const (
+	// SignatureHeader holds the HMAC-SHA256 signature of the payload, as
+	// "sha256=" followed by its hex encoding.
+	SignatureHeader = "X-Rbac-Signature"
+	// DeliveryHeader holds the ID of the delivery, which is the same for its
+	// retries.
+	DeliveryHeader = "X-Rbac-Delivery"
+
+	// LostOperation is the operation of the payload stored as a dead letter of
+	// every webhook when changes were compacted before their delivery. Its
+	// Revision is the last delivered one, the receiver reads the rules again.
+	LostOperation = "Lost"
+
+	// webhookQueueSize is the number of payloads a webhook may lag behind
+	// before they are stored as dead letters.
+	webhookQueueSize = 256
+)
+
+// Webhook is a URL called with the changes of the rules.
+type Webhook struct {
+	ID        string
+	URL       string
+	Secret    string
+	CreatedAt time.Time
+}
+
+// DeadLetter is a payload which couldn't be delivered to a webhook.
+type DeadLetter struct {
+	ID        int64
+	WebhookID string
+	URL       string
+	Payload   []byte
+	// Error is the error of the last attempt.
+	Error     string
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// WebhookStore stores the webhooks and the dead letters.
+type WebhookStore interface {
+	// AddWebhook stores the webhook, ErrWebhookExists means its URL is
+	// already registered.
+	AddWebhook(ctx context.Context, w *Webhook) error
+	DelWebhook(ctx context.Context, id string) error
+	ListWebhooks(ctx context.Context) ([]*Webhook, error)
+	// AddDeadLetter stores the dead letter and sets its ID.
+	AddDeadLetter(ctx context.Context, d *DeadLetter) error
+	GetDeadLetter(ctx context.Context, id int64) (*DeadLetter, error)
+	// ListDeadLetters returns the dead letters of the webhook, or every one
+	// when webhookID is empty, the oldest first.
+	ListDeadLetters(ctx context.Context, webhookID string, limit int) ([]*DeadLetter, error)
+	DelDeadLetter(ctx context.Context, id int64) error
+}
+
+// WebhookConfig configures the webhooks.
+type WebhookConfig struct {
+	// Webhooks are registered when the webhooks are enabled, unless their URL
+	// is already registered. An ID and a secret are generated when empty.
+	Webhooks []*Webhook
+	// Store keeps the webhooks and the dead letters, in memory by default.
+	Store WebhookStore
+	// MaxAttempts is the number of attempts of a delivery before it is stored
+	// as a dead letter, 5 by default.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled by each retry up to
+	// MaxBackoff. One second and one minute by default.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Client sends the requests, with a 10 seconds timeout by default. The
+	// default client refuses to connect to a private address unless
+	// AllowPrivateHosts is set.
+	Client *http.Client
+	// AllowPrivateHosts accepts the webhooks whose host is a loopback,
+	// private, link-local or unspecified address, such as a cloud metadata
+	// endpoint. They are rejected by default, since any caller of AddWebhook
+	// could otherwise make the server post to its internal network.
+	AllowPrivateHosts bool
+}
+
+// WebhookPayload is the JSON body sent to the webhooks for each change of
+// the rules.
+type WebhookPayload struct {
+	Revision int64     `json:"revision"`
+	Time     time.Time `json:"time"`
+	// Actor is the subject which made the change.
+	Actor     string          `json:"actor,omitempty"`
+	Operation string          `json:"operation"`
+	Reason    string          `json:"reason,omitempty"`
+	Events    []*WebhookEvent `json:"events"`
+}
+
+// WebhookEvent is a changed rule. Policy is set for a p rule, and Subject for
+// a g or g2 rule. The previous rule of an update is in PreviousPolicy or
+// PreviousSubject.
+type WebhookEvent struct {
+	// Type is "added", "removed" or "updated".
+	Type            string       `json:"type"`
+	Policy          *api.Policy  `json:"policy,omitempty"`
+	Subject         *api.Subject `json:"subject,omitempty"`
+	PreviousPolicy  *api.Policy  `json:"previousPolicy,omitempty"`
+	PreviousSubject *api.Subject `json:"previousSubject,omitempty"`
+}
+
+// Sign returns the value of SignatureHeader for the payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the value of SignatureHeader
+// for the payload.
+func VerifySignature(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
+
+// WebhookDispatcher sends the changes of the rules to the webhooks.
+type WebhookDispatcher struct {
+	WebhookConfig
+
+	r      rbac.RBAC
+	cancel context.CancelFunc
+	done   chan struct{}
+	// queues holds the pending deliveries of each webhook, by ID. It is only
+	// used by run.
+	queues map[string]chan *webhookDelivery
+	// workers waits for the goroutines delivering the queues
+	workers sync.WaitGroup
+}
+
+// webhookDelivery is a payload waiting in the queue of a webhook.
+type webhookDelivery struct {
+	id   string
+	body []byte
+}
+
+// EnableWebhooks sends the changes of the rules to the webhooks, and enables
+// the webhook RPCs. The returned dispatcher is stopped by Close.
+func (s *RBACServer) EnableWebhooks(cfg WebhookConfig) (*WebhookDispatcher, error) {
+	d, err := newWebhookDispatcher(s.r, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.webhooks = d
+	return d, nil
+}
+
+func newWebhookDispatcher(r rbac.RBAC, cfg WebhookConfig) (*WebhookDispatcher, error) {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryWebhookStore()
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+		if !cfg.AllowPrivateHosts {
+			// the address is checked again when connecting, since the name
+			// may resolve to another address than when the webhook was added
+			dialer := &net.Dialer{Timeout: 10 * time.Second, Control: dialPublic}
+			cfg.Client.Transport = &http.Transport{DialContext: dialer.DialContext}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &WebhookDispatcher{
+		WebhookConfig: cfg,
+		r:             r,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		queues:        map[string]chan *webhookDelivery{},
+	}
+
+	for _, w := range cfg.Webhooks {
+		if err := d.add(ctx, w); err != nil && !errors.Is(err, ErrWebhookExists) {
+			cancel()
+			return nil, err
+		}
+	}
+
+	events, err := r.Watch(ctx, "", 0)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go d.run(ctx, events)
+
+	return d, nil
+}
+
+// add registers the webhook, with a generated ID and secret when empty.
+func (d *WebhookDispatcher) add(ctx context.Context, w *Webhook) error {
+	if err := d.validateURL(ctx, w.URL); err != nil {
+		return err
+	}
+	if w.ID == "" {
+		w.ID = randomHex(8)
+	}
+	if w.Secret == "" {
+		w.Secret = randomHex(32)
+	}
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = time.Now()
+	}
+	return d.Store.AddWebhook(ctx, w)
+}
+
+// validateURL checks that raw is an absolute http or https URL, whose host
+// resolves to public addresses unless AllowPrivateHosts is set.
+func (d *WebhookDispatcher) validateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrInvalidWebhookURL
+	}
+	if d.AllowPrivateHosts {
+		return nil
+	}
+
+	ips := []net.IP{net.ParseIP(u.Hostname())}
+	if ips[0] == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidWebhookURL, err)
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if privateIP(ip) {
+			return ErrPrivateWebhookURL
+		}
+	}
+	return nil
+}
+
+// privateIP reports whether ip isn't a public unicast address.
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// dialPublic refuses the connections to a private address.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return ErrPrivateWebhookURL
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// run delivers the changes until ctx is done or the RBAC is closed. When the
+// watch is dropped, it resumes after the last delivered revision. The changes
+// loaded from another replica are skipped, that replica delivers them.
+func (d *WebhookDispatcher) run(ctx context.Context, events <-chan *rbac.WatchEvent) {
+	defer func() {
+		for id, queue := range d.queues {
+			close(queue)
+			delete(d.queues, id)
+		}
+		d.workers.Wait()
+		close(d.done)
+	}()
+
+	epoch, revision := "", int64(0)
+	for {
+		for ev := range events {
+			epoch, revision = ev.Epoch, ev.Revision
+			if len(ev.Events) > 0 && ev.Operation != rbac.ReloadOperation {
+				d.dispatch(ctx, ev)
+			}
+		}
+		if events = d.rewatch(ctx, epoch, revision); events == nil {
+			return
+		}
+	}
+}
+
+// rewatch watches the changes after the revision of the epoch again, and
+// waits between the failed attempts. When the changes were compacted, they
+// are stored as dead letters and the watch starts from now on. It returns
+// nil when ctx is done or the RBAC is closed.
+func (d *WebhookDispatcher) rewatch(ctx context.Context, epoch string, revision int64) <-chan *rbac.WatchEvent {
+	backoff := d.Backoff
+	for ctx.Err() == nil {
+		events, err := d.r.Watch(ctx, epoch, revision)
+		if err == nil {
+			return events
+		}
+		if errors.Is(err, rbac.ErrRevisionCompacted) {
+			d.lost(ctx, revision)
+			revision = 0
+			continue
+		}
+		if errors.Is(err, rbac.ErrClosed) {
+			log.Errorf("webhooks: stop the deliveries: %v", err)
+			return nil
+		}
+
+		log.Errorf("webhooks: watch the changes after revision %d: %v", revision, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+	return nil
+}
+
+// lost stores a LostOperation payload as a dead letter of every webhook,
+// since the changes after revision can't be delivered.
+func (d *WebhookDispatcher) lost(ctx context.Context, revision int64) {
+	log.Errorf("webhooks: the changes after revision %d were compacted before their delivery", revision)
+
+	body, err := json.Marshal(&WebhookPayload{
+		Revision:  revision,
+		Time:      time.Now(),
+		Operation: LostOperation,
+		Events:    []*WebhookEvent{},
+	})
+	if err != nil {
+		log.Errorf("webhooks: encode the lost changes: %v", err)
+		return
+	}
+	webhooks, err := d.listWebhooks(ctx)
+	if err != nil {
+		log.Errorf("webhooks: record the lost changes: %v", err)
+		return
+	}
+
+	err = fmt.Errorf("the changes after revision %d were compacted", revision)
+	for _, w := range webhooks {
+		d.deadLetter(w, body, err, 0)
+	}
+}
+
+// listWebhooks lists the webhooks, with the retries of a delivery.
+func (d *WebhookDispatcher) listWebhooks(ctx context.Context) ([]*Webhook, error) {
+	backoff := d.Backoff
+	for attempts := 1; ; attempts++ {
+		webhooks, err := d.Store.ListWebhooks(ctx)
+		if err == nil || attempts >= d.MaxAttempts {
+			return webhooks, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+}
+
+// dispatch queues the change for every webhook. Each webhook has its own
+// queue, delivered in order, so that a slow webhook doesn't hold back the
+// others. When the queue of a webhook is full, the change is stored as a
+// dead letter.
+func (d *WebhookDispatcher) dispatch(ctx context.Context, ev *rbac.WatchEvent) {
+	payload := &WebhookPayload{
+		Revision:  ev.Revision,
+		Time:      ev.Time,
+		Actor:     ev.Actor,
+		Operation: ev.Operation,
+		Reason:    ev.Reason,
+		Events:    make([]*WebhookEvent, 0, len(ev.Events)),
+	}
+	for _, e := range ev.Events {
+		payload.Events = append(payload.Events, &WebhookEvent{
+			Type:            strings.ToLower(e.Type.String()),
+			Policy:          e.Policy,
+			Subject:         e.Subject,
+			PreviousPolicy:  e.PreviousPolicy,
+			PreviousSubject: e.PreviousSubject,
+		})
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("webhooks: encode revision %d: %v", ev.Revision, err)
+		return
+	}
+
+	webhooks, err := d.listWebhooks(ctx)
+	if err != nil {
+		log.Errorf("webhooks: drop revision %d: list the webhooks: %v", ev.Revision, err)
+		return
+	}
+
+	item := &webhookDelivery{id: randomHex(8), body: body}
+	registered := make(map[string]struct{}, len(webhooks))
+	for _, w := range webhooks {
+		registered[w.ID] = struct{}{}
+		select {
+		case d.queue(ctx, w) <- item:
+		default:
+			d.deadLetter(w, body, ErrWebhookQueueFull, 0)
+		}
+	}
+
+	// the deleted webhooks stop once their queue is drained
+	for id, queue := range d.queues {
+		if _, ok := registered[id]; !ok {
+			close(queue)
+			delete(d.queues, id)
+		}
+	}
+}
+
+// queue returns the queue of the webhook, and starts delivering it when it
+// is new.
+func (d *WebhookDispatcher) queue(ctx context.Context, w *Webhook) chan<- *webhookDelivery {
+	if queue, ok := d.queues[w.ID]; ok {
+		return queue
+	}
+
+	queue := make(chan *webhookDelivery, webhookQueueSize)
+	d.queues[w.ID] = queue
+	d.workers.Add(1)
+	go func() {
+		defer d.workers.Done()
+		for item := range queue {
+			if ctx.Err() != nil {
+				return
+			}
+			d.deliver(ctx, w, item.id, item.body)
+		}
+	}()
+	return queue
+}
+
+// deliver sends the payload to the webhook with retries, and stores it as a
+// dead letter when every attempt failed.
+func (d *WebhookDispatcher) deliver(ctx context.Context, w *Webhook, delivery string, body []byte) {
+	backoff := d.Backoff
+	attempts := 0
+	var err error
+	for attempts < d.MaxAttempts {
+		if attempts > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > d.MaxBackoff {
+				backoff = d.MaxBackoff
+			}
+		}
+
+		attempts++
+		var retry bool
+		if retry, err = d.send(ctx, w, delivery, body); err == nil || !retry {
+			break
+		}
+	}
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	d.deadLetter(w, body, err, attempts)
+}
+
+// deadLetter stores the payload which couldn't be delivered to the webhook.
+func (d *WebhookDispatcher) deadLetter(w *Webhook, body []byte, err error, attempts int) {
+	letter := &DeadLetter{
+		WebhookID: w.ID,
+		URL:       w.URL,
+		Payload:   body,
+		Error:     err.Error(),
+		Attempts:  attempts,
+		CreatedAt: time.Now(),
+	}
+	if e := d.Store.AddDeadLetter(context.Background(), letter); e != nil {
+		log.Errorf("webhooks: store the dead letter of %s: %v", w.ID, e)
+	}
+}
+
+// send posts the payload to the webhook. The request is retried when it
+// fails to be sent, or with a 408, 429 or 5xx status.
+func (d *WebhookDispatcher) send(ctx context.Context, w *Webhook, delivery string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	req.Header.Set(DeliveryHeader, delivery)
+
+	rsp, err := d.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer rsp.Body.Close()
+	_, _ = io.Copy(io.Discard, rsp.Body)
+
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook returned status %d", rsp.StatusCode)
+	retry = rsp.StatusCode == http.StatusRequestTimeout || rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode >= 500
+	return retry, err
+}
+
+// Redeliver sends the dead letter again, once. It is removed when the
+// delivery succeeds.
+func (d *WebhookDispatcher) Redeliver(ctx context.Context, id int64) error {
+	letter, err := d.Store.GetDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := d.Store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	var webhook *Webhook
+	for _, w := range webhooks {
+		if w.ID == letter.WebhookID {
+			webhook = w
+		}
+	}
+	if webhook == nil {
+		return ErrWebhookNotFound
+	}
+
+	if _, err = d.send(ctx, webhook, "dead-letter-"+strconv.FormatInt(id, 10), letter.Payload); err != nil {
+		return err
+	}
+	return d.Store.DelDeadLetter(ctx, id)
+}
+
+// Close stops the deliveries, the retries in progress are abandoned.
+func (d *WebhookDispatcher) Close() error {
+	d.cancel()
+	<-d.done
+	return nil
+}
+
+// memoryWebhookStore keeps the webhooks and the dead letters in memory.
+type memoryWebhookStore struct {
+	sync.RWMutex
+	webhooks    map[string]*Webhook
+	deadLetters []*DeadLetter
+	nextID      int64
+}
+
+// NewMemoryWebhookStore returns a WebhookStore which keeps the webhooks and
+// the dead letters in memory.
+func NewMemoryWebhookStore() WebhookStore {
+	return &memoryWebhookStore{webhooks: map[string]*Webhook{}}
+}
+
+func (s *memoryWebhookStore) AddWebhook(ctx context.Context, w *Webhook) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range s.webhooks {
+		if item.URL == w.URL {
+			return ErrWebhookExists
+		}
+	}
+	s.webhooks[w.ID] = w
+	return nil
+}
+
+func (s *memoryWebhookStore) DelWebhook(ctx context.Context, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *memoryWebhookStore) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(s.webhooks))
+	for _, w := range s.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return webhooks, nil
+}
+
+func (s *memoryWebhookStore) AddDeadLetter(ctx context.Context, d *DeadLetter) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.nextID++
+	d.ID = s.nextID
+	s.deadLetters = append(s.deadLetters, d)
+	return nil
+}
+
+func (s *memoryWebhookStore) GetDeadLetter(ctx context.Context, id int64) (*DeadLetter, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, d := range s.deadLetters {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return nil, ErrDeadLetterNotFound
+}
+
+func (s *memoryWebhookStore) ListDeadLetters(ctx context.Context, webhookID string, limit int) ([]*DeadLetter, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	letters := make([]*DeadLetter, 0)
+	for _, d := range s.deadLetters {
+		if webhookID != "" && d.WebhookID != webhookID {
+			continue
+		}
+		letters = append(letters, d)
+		if limit > 0 && len(letters) == limit {
+			break
+		}
+	}
+	return letters, nil
+}
+
+func (s *memoryWebhookStore) DelDeadLetter(ctx context.Context, id int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, d := range s.deadLetters {
+		if d.ID == id {
+			s.deadLetters = append(s.deadLetters[:i], s.deadLetters[i+1:]...)
+			return nil
+		}
+	}
+	return ErrDeadLetterNotFound
+}
+
+func (s *RBACServer) AddWebhook(ctx context.Context, req *api.AddWebhookRequest, rsp *api.AddWebhookResponse) (err error) {
+	if s.webhooks == nil {
+		return verrs.NotImplemented(s.Name(), ErrWebhooksDisabled.Error())
+	}
+	if req.Url == "" {
+		return verrs.BadRequest(s.Name(), "missing url")
+	}
+
+	w := &Webhook{URL: req.Url, Secret: req.Secret}
+	err = s.webhooks.add(ctx, w)
+	if errors.Is(err, ErrInvalidWebhookURL) || errors.Is(err, ErrPrivateWebhookURL) {
+		return verrs.BadRequest(s.Name(), err.Error())
+	}
+	if errors.Is(err, ErrWebhookExists) {
+		return verrs.Conflict(s.Name(), err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	rsp.Webhook = toWebhook(w)
+	rsp.Webhook.Secret = w.Secret
+	return
+}
+
+func (s *RBACServer) DelWebhook(ctx context.Context, req *api.DelWebhookRequest, rsp *api.DelWebhookResponse) (err error) {
+	if s.webhooks == nil {
+		return verrs.NotImplemented(s.Name(), ErrWebhooksDisabled.Error())
+	}
+	if req.Id == "" {
+		return verrs.BadRequest(s.Name(), "missing id")
+	}
+
+	err = s.webhooks.Store.DelWebhook(ctx, req.Id)
+	if errors.Is(err, ErrWebhookNotFound) {
+		return verrs.NotFound(s.Name(), err.Error())
+	}
+	return err
+}
+
+func (s *RBACServer) ListWebhooks(ctx context.Context, req *api.ListWebhooksRequest, rsp *api.ListWebhooksResponse) (err error) {
+	if s.webhooks == nil {
+		return verrs.NotImplemented(s.Name(), ErrWebhooksDisabled.Error())
+	}
+
+	webhooks, err := s.webhooks.Store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	rsp.Webhooks = make([]*api.Webhook, 0, len(webhooks))
+	for _, w := range webhooks {
+		rsp.Webhooks = append(rsp.Webhooks, toWebhook(w))
+	}
+	return
+}
+
+func (s *RBACServer) ListDeadLetters(ctx context.Context, req *api.ListDeadLettersRequest, rsp *api.ListDeadLettersResponse) (err error) {
+	if s.webhooks == nil {
+		return verrs.NotImplemented(s.Name(), ErrWebhooksDisabled.Error())
+	}
+
+	letters, err := s.webhooks.Store.ListDeadLetters(ctx, req.WebhookId, int(req.Limit))
+	if err != nil {
+		return err
+	}
+	rsp.DeadLetters = make([]*api.DeadLetter, 0, len(letters))
+	for _, d := range letters {
+		rsp.DeadLetters = append(rsp.DeadLetters, &api.DeadLetter{
+			Id:        d.ID,
+			WebhookId: d.WebhookID,
+			Url:       d.URL,
+			Payload:   d.Payload,
+			Error:     d.Error,
+			Attempts:  int32(d.Attempts),
+			Timestamp: d.CreatedAt.Unix(),
+		})
+	}
+	return
+}
+
+func (s *RBACServer) RedeliverDeadLetter(ctx context.Context, req *api.RedeliverDeadLetterRequest, rsp *api.RedeliverDeadLetterResponse) (err error) {
+	if s.webhooks == nil {
+		return verrs.NotImplemented(s.Name(), ErrWebhooksDisabled.Error())
+	}
+
+	err = s.webhooks.Redeliver(ctx, req.Id)
+	if errors.Is(err, ErrDeadLetterNotFound) || errors.Is(err, ErrWebhookNotFound) {
+		return verrs.NotFound(s.Name(), err.Error())
+	}
+	if err != nil {
+		return verrs.BadGateway(s.Name(), err.Error())
+	}
+	return
+}
+
+// toWebhook returns the webhook without its secret.
+func toWebhook(w *Webhook) *api.Webhook {
+	return &api.Webhook{Id: w.ID, Url: w.URL, Timestamp: w.CreatedAt.Unix()}
+}
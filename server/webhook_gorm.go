@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// webhookRow is a row of the rbac_webhook table.
+type webhookRow struct {
+	ID        string    `gorm:"column:id;primaryKey;size:64"`
+	URL       string    `gorm:"column:url;size:512;uniqueIndex"`
+	Secret    string    `gorm:"column:secret;size:128"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (webhookRow) TableName() string {
+	return "rbac_webhook"
+}
+
+// deadLetterRow is a row of the rbac_dead_letter table.
+type deadLetterRow struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement"`
+	WebhookID string    `gorm:"column:webhook_id;size:64;index"`
+	URL       string    `gorm:"column:url;size:512"`
+	Payload   []byte    `gorm:"column:payload"`
+	Error     string    `gorm:"column:error"`
+	Attempts  int       `gorm:"column:attempts"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (deadLetterRow) TableName() string {
+	return "rbac_dead_letter"
+}
+
+// GormWebhookStore stores the webhooks in the rbac_webhook table, and the
+// dead letters in the rbac_dead_letter table.
+type GormWebhookStore struct {
+	db *gorm.DB
+}
+
+var _ WebhookStore = (*GormWebhookStore)(nil)
+
+// NewGormWebhookStore is the constructor for GormWebhookStore.
+func NewGormWebhookStore(db *gorm.DB) (*GormWebhookStore, error) {
+	if err := db.AutoMigrate(&webhookRow{}, &deadLetterRow{}); err != nil {
+		return nil, err
+	}
+
+	return &GormWebhookStore{db: db}, nil
+}
+
+func (s *GormWebhookStore) AddWebhook(ctx context.Context, w *Webhook) error {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&webhookRow{}).Where("url = ?", w.URL).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrWebhookExists
+	}
+
+	row := &webhookRow{ID: w.ID, URL: w.URL, Secret: w.Secret, CreatedAt: w.CreatedAt}
+	return s.db.WithContext(ctx).Create(row).Error
+}
+
+func (s *GormWebhookStore) DelWebhook(ctx context.Context, id string) error {
+	tx := s.db.WithContext(ctx).Where("id = ?", id).Delete(&webhookRow{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *GormWebhookStore) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	rows := make([]*webhookRow, 0)
+	if err := s.db.WithContext(ctx).Order("created_at").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*Webhook, 0, len(rows))
+	for _, row := range rows {
+		webhooks = append(webhooks, &Webhook{ID: row.ID, URL: row.URL, Secret: row.Secret, CreatedAt: row.CreatedAt})
+	}
+	return webhooks, nil
+}
+
+func (s *GormWebhookStore) AddDeadLetter(ctx context.Context, d *DeadLetter) error {
+	row := &deadLetterRow{
+		WebhookID: d.WebhookID,
+		URL:       d.URL,
+		Payload:   d.Payload,
+		Error:     d.Error,
+		Attempts:  d.Attempts,
+		CreatedAt: d.CreatedAt,
+	}
+	if err := s.db.WithContext(ctx).Create(row).Error; err != nil {
+		return err
+	}
+	d.ID = row.ID
+	return nil
+}
+
+func (s *GormWebhookStore) GetDeadLetter(ctx context.Context, id int64) (*DeadLetter, error) {
+	row := &deadLetterRow{}
+	err := s.db.WithContext(ctx).Where("id = ?", id).First(row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromDeadLetterRow(row), nil
+}
+
+func (s *GormWebhookStore) ListDeadLetters(ctx context.Context, webhookID string, limit int) ([]*DeadLetter, error) {
+	db := s.db.WithContext(ctx).Order("id")
+	if webhookID != "" {
+		db = db.Where("webhook_id = ?", webhookID)
+	}
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+
+	rows := make([]*deadLetterRow, 0)
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	letters := make([]*DeadLetter, 0, len(rows))
+	for _, row := range rows {
+		letters = append(letters, fromDeadLetterRow(row))
+	}
+	return letters, nil
+}
+
+func (s *GormWebhookStore) DelDeadLetter(ctx context.Context, id int64) error {
+	tx := s.db.WithContext(ctx).Where("id = ?", id).Delete(&deadLetterRow{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func fromDeadLetterRow(row *deadLetterRow) *DeadLetter {
+	return &DeadLetter{
+		ID:        row.ID,
+		WebhookID: row.WebhookID,
+		URL:       row.URL,
+		Payload:   row.Payload,
+		Error:     row.Error,
+		Attempts:  row.Attempts,
+		CreatedAt: row.CreatedAt,
+	}
+}
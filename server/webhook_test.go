@@ -0,0 +1,421 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/adapter"
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/vine"
+	verrs "github.com/vine-io/vine/lib/errors"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newWebhookServer(t *testing.T, opts ...rbac.Option) (*RBACServer, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "webhook.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewRBACServerWithApt(vine.NewService(vine.Name(name)), apt, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, db
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.TODO()
+	server, db := newWebhookServer(t)
+
+	// a webhook RPC fails until the webhooks are enabled
+	err := server.ListWebhooks(ctx, &api.ListWebhooksRequest{}, &api.ListWebhooksResponse{})
+	if e := verrs.FromErr(err); e == nil || e.Code != http.StatusNotImplemented {
+		t.Fatalf("ListWebhooks() = %v, want NotImplemented", err)
+	}
+
+	var mu sync.Mutex
+	payloads := make([]*WebhookPayload, 0)
+	failures := 1
+	secret := ""
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if !VerifySignature(secret, body, r.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// the first attempt fails, and is retried
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		payload := &WebhookPayload{}
+		_ = json.Unmarshal(body, payload)
+		payloads = append(payloads, payload)
+	}))
+	defer ok.Close()
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer gone.Close()
+
+	store, err := NewGormWebhookStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := server.EnableWebhooks(WebhookConfig{
+		Webhooks:          []*Webhook{{URL: gone.URL}},
+		Store:             store,
+		MaxAttempts:       3,
+		Backoff:           10 * time.Millisecond,
+		AllowPrivateHosts: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	added := &api.AddWebhookResponse{}
+	if err = server.AddWebhook(ctx, &api.AddWebhookRequest{Url: ok.URL}, added); err != nil {
+		t.Fatal(err)
+	}
+	if added.Webhook.Secret == "" {
+		t.Fatal("AddWebhook() didn't generate a secret")
+	}
+	mu.Lock()
+	secret = added.Webhook.Secret
+	mu.Unlock()
+	err = server.AddWebhook(ctx, &api.AddWebhookRequest{Url: ok.URL}, &api.AddWebhookResponse{})
+	if e := verrs.FromErr(err); e == nil || e.Code != http.StatusConflict {
+		t.Fatalf("AddWebhook() = %v, want Conflict", err)
+	}
+	for _, url := range []string{"ftp://example.com/hook", "/hook", "http://", "://example.com"} {
+		err = server.AddWebhook(ctx, &api.AddWebhookRequest{Url: url}, &api.AddWebhookResponse{})
+		if e := verrs.FromErr(err); e == nil || e.Code != http.StatusBadRequest {
+			t.Fatalf("AddWebhook(%q) = %v, want BadRequest", url, err)
+		}
+	}
+
+	list := &api.ListWebhooksResponse{}
+	if err = server.ListWebhooks(ctx, &api.ListWebhooksRequest{}, list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Webhooks) != 2 || list.Webhooks[1].Secret != "" {
+		t.Fatalf("ListWebhooks() = %v, want 2 webhooks without secret", list.Webhooks)
+	}
+	goneID := list.Webhooks[0].Id
+
+	policy := api.NewPolicyWithString("alice", "data1", "read")
+	if err = server.AddPolicy(ctx, &api.AddPolicyRequest{Policy: policy}, &api.AddPolicyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	letters := &api.ListDeadLettersResponse{}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(payloads)
+		mu.Unlock()
+		letters = &api.ListDeadLettersResponse{}
+		if err = server.ListDeadLetters(ctx, &api.ListDeadLettersRequest{}, letters); err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 && len(letters.DeadLetters) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d payloads and %d dead letters, want 1 and 1", n, len(letters.DeadLetters))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	payload := payloads[0]
+	mu.Unlock()
+	if payload.Operation != "AddPolicy" || len(payload.Events) != 1 || payload.Events[0].Type != "added" ||
+		payload.Events[0].Policy.Sub != "alice" {
+		t.Fatalf("payload = %+v, want alice added", payload)
+	}
+
+	// a 4xx status isn't retried
+	letter := letters.DeadLetters[0]
+	if letter.WebhookId != goneID || letter.Attempts != 1 {
+		t.Fatalf("dead letter = %v, want a single attempt to %s", letter, goneID)
+	}
+	err = server.RedeliverDeadLetter(ctx, &api.RedeliverDeadLetterRequest{Id: letter.Id}, &api.RedeliverDeadLetterResponse{})
+	if e := verrs.FromErr(err); e == nil || e.Code != http.StatusBadGateway {
+		t.Fatalf("RedeliverDeadLetter() = %v, want BadGateway", err)
+	}
+
+	if err = server.DelWebhook(ctx, &api.DelWebhookRequest{Id: goneID}, &api.DelWebhookResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	err = server.RedeliverDeadLetter(ctx, &api.RedeliverDeadLetterRequest{Id: letter.Id}, &api.RedeliverDeadLetterResponse{})
+	if e := verrs.FromErr(err); e == nil || e.Code != http.StatusNotFound {
+		t.Fatalf("RedeliverDeadLetter() = %v, want NotFound", err)
+	}
+}
+
+func TestWebhookStoreRedeliver(t *testing.T) {
+	ctx := context.TODO()
+	server, _ := newWebhookServer(t)
+
+	received := make(chan []byte, 1)
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer ok.Close()
+
+	store := NewMemoryWebhookStore()
+	w := &Webhook{ID: "hook", URL: ok.URL, Secret: "secret", CreatedAt: time.Now()}
+	if err := store.AddWebhook(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	letter := &DeadLetter{WebhookID: w.ID, URL: w.URL, Payload: []byte(`{}`), Attempts: 5, CreatedAt: time.Now()}
+	if err := store.AddDeadLetter(ctx, letter); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := server.EnableWebhooks(WebhookConfig{Store: store, AllowPrivateHosts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err = server.RedeliverDeadLetter(ctx, &api.RedeliverDeadLetterRequest{Id: letter.ID}, &api.RedeliverDeadLetterResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if body := <-received; string(body) != `{}` {
+		t.Fatalf("redelivered %s, want {}", body)
+	}
+	if _, err = store.GetDeadLetter(ctx, letter.ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("GetDeadLetter() = %v, want ErrDeadLetterNotFound", err)
+	}
+}
+
+// reloadWatcher calls back the rbac when the test reloads the rules.
+type reloadWatcher struct {
+	callback func(string)
+}
+
+func (w *reloadWatcher) SetUpdateCallback(callback func(string)) error {
+	w.callback = callback
+	return nil
+}
+
+func (w *reloadWatcher) Update() error { return nil }
+
+func (w *reloadWatcher) Close() {}
+
+func TestWebhookSkipsReload(t *testing.T) {
+	ctx := context.TODO()
+	watcher := &reloadWatcher{}
+	server, db := newWebhookServer(t, rbac.WithWatcher(watcher))
+
+	operations := make(chan string, 10)
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := &WebhookPayload{}
+		_ = json.NewDecoder(r.Body).Decode(payload)
+		operations <- payload.Operation
+	}))
+	defer ok.Close()
+
+	d, err := server.EnableWebhooks(WebhookConfig{Webhooks: []*Webhook{{URL: ok.URL}}, AllowPrivateHosts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// another replica adds a rule, which that replica delivers
+	other, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = other.AddPolicy("p", "p", []string{"bob", "data1", "read"}); err != nil {
+		t.Fatal(err)
+	}
+	watcher.callback("")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rsp := &api.GetPoliciesResponse{}
+		if err = server.GetPolicies(ctx, &api.GetPoliciesRequest{Sub: "bob"}, rsp); err != nil {
+			t.Fatal(err)
+		}
+		if len(rsp.Policies) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the rules weren't reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	policy := api.NewPolicyWithString("alice", "data1", "read")
+	if err = server.AddPolicy(ctx, &api.AddPolicyRequest{Policy: policy}, &api.AddPolicyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the deliveries of a webhook are in order
+	select {
+	case operation := <-operations:
+		if operation != "AddPolicy" {
+			t.Fatalf("delivered %s, want AddPolicy", operation)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddPolicy wasn't delivered")
+	}
+}
+
+func TestWebhookSlowEndpoint(t *testing.T) {
+	ctx := context.TODO()
+	server, _ := newWebhookServer(t)
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	received := make(chan struct{}, 10)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer fast.Close()
+
+	d, err := server.EnableWebhooks(WebhookConfig{Webhooks: []*Webhook{{URL: slow.URL}, {URL: fast.URL}}, AllowPrivateHosts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	defer close(release)
+
+	for _, sub := range []string{"alice", "bob"} {
+		policy := api.NewPolicyWithString(sub, "data1", "read")
+		if err = server.AddPolicy(ctx, &api.AddPolicyRequest{Policy: policy}, &api.AddPolicyResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the fast webhook doesn't wait for the slow one
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d deliveries, want 2", i)
+		}
+	}
+}
+
+func TestWebhookRBACClosed(t *testing.T) {
+	server, _ := newWebhookServer(t)
+
+	d, err := server.EnableWebhooks(WebhookConfig{AllowPrivateHosts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// the dispatcher stops instead of watching a closed RBAC again
+	if err = server.r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-d.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the dispatcher didn't stop")
+	}
+	if _, err = server.r.Watch(context.TODO(), "", 0); !errors.Is(err, rbac.ErrClosed) {
+		t.Fatalf("Watch() = %v, want ErrClosed", err)
+	}
+}
+
+func TestWebhookLostChanges(t *testing.T) {
+	ctx := context.TODO()
+	server, _ := newWebhookServer(t, rbac.WithWatchHistory(1))
+
+	store := NewMemoryWebhookStore()
+	d, err := server.EnableWebhooks(WebhookConfig{Store: store, Webhooks: []*Webhook{{URL: "http://127.0.0.1:1/hook"}}, AllowPrivateHosts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, sub := range []string{"alice", "bob", "carol"} {
+		policy := api.NewPolicyWithString(sub, "data1", "read")
+		if err = server.AddPolicy(ctx, &api.AddPolicyRequest{Policy: policy}, &api.AddPolicyResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := server.r.Watch(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := (<-events).Epoch
+
+	// the changes after revision 1 are compacted, they are recorded
+	if events := d.rewatch(ctx, epoch, 1); events == nil {
+		t.Fatal("rewatch() = nil, want a new watch")
+	}
+	letters, err := store.ListDeadLetters(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lost *WebhookPayload
+	for _, letter := range letters {
+		payload := &WebhookPayload{}
+		if err = json.Unmarshal(letter.Payload, payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Operation == LostOperation {
+			lost = payload
+		}
+	}
+	if lost == nil || lost.Revision != 1 {
+		t.Fatalf("dead letters = %v, want the changes lost after revision 1", letters)
+	}
+}
+
+func TestWebhookPrivateHosts(t *testing.T) {
+	ctx := context.TODO()
+	server, _ := newWebhookServer(t)
+
+	d, err := server.EnableWebhooks(WebhookConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://169.254.169.254/latest", "http://10.0.0.1/hook", "http://[::1]/hook"} {
+		err = server.AddWebhook(ctx, &api.AddWebhookRequest{Url: url}, &api.AddWebhookResponse{})
+		if e := verrs.FromErr(err); e == nil || e.Code != http.StatusBadRequest {
+			t.Fatalf("AddWebhook(%q) = %v, want BadRequest", url, err)
+		}
+	}
+
+	// the default client refuses a private address resolved after the check
+	w := &Webhook{ID: "hook", URL: "http://127.0.0.1:1/hook"}
+	if _, err = d.send(ctx, w, "delivery", []byte(`{}`)); !errors.Is(err, ErrPrivateWebhookURL) {
+		t.Fatalf("send() = %v, want ErrPrivateWebhookURL", err)
+	}
+}
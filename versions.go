@@ -0,0 +1,309 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/vine-io/rbac/adapter"
+	"github.com/vine-io/rbac/api"
+	log "github.com/vine-io/vine/lib/logger"
+)
+
+// Version is a change of the rules made through RBAC. Version 0 is the state
+// before the first recorded version.
+type Version struct {
+	ID        int64
+	CreatedAt time.Time
+	Diff      *Diff
+}
+
+// commit records the changes applied by an operation as a new version, and
+// in the audit log when it is enabled. The permission index is updated, the
+// cached results affected by the changes are evicted, and the watchers of
+// Watch, the watcher and the listeners of OnChange are notified.
+//
+// The version is recorded first: when it fails, the changes are reverted so
+// that the history matches the rules. The changes are kept when the audit log
+// or the watcher fails, and the error is logged.
+func (r *rbac) commit(ctx context.Context, operation string, changes ...ruleChange) error {
+	v := &adapter.Version{CreatedAt: time.Now()}
+	for _, c := range changes {
+		for _, rule := range c.added {
+			v.Added = append(v.Added, append([]string{c.ptype}, rule...))
+		}
+		for _, rule := range c.removed {
+			v.Removed = append(v.Removed, append([]string{c.ptype}, rule...))
+		}
+	}
+	if len(v.Added)+len(v.Removed) == 0 {
+		return nil
+	}
+
+	if err := r.versions.AddVersion(v); err != nil {
+		if revertErr := r.revertChanges(changes); revertErr != nil {
+			return fmt.Errorf("%w: record version: %v, revert: %v", ErrCasbin, err, revertErr)
+		}
+		return fmt.Errorf("%w: record version: %v", ErrCasbin, err)
+	}
+
+	r.updateIndex(changes)
+	r.invalidateChanges(changes)
+	revision := r.watchers.publish(ctx, operation, changes)
+
+	if r.audit != nil {
+		if err := r.audit.Write(ctx, auditRecords(ctx, operation, changes)...); err != nil {
+			log.Errorf("rbac: %s: write audit log: %v", operation, err)
+		}
+	}
+
+	if r.watcher != nil {
+		if err := r.watcher.Update(); err != nil {
+			log.Errorf("rbac: %s: notify watcher: %v", operation, err)
+		}
+	}
+
+	if r.hooks != nil {
+		r.hooks.notify(&ChangeEvent{
+			Actor:     Actor(ctx),
+			Operation: operation,
+			Reason:    Reason(ctx),
+			Revision:  revision,
+			Epoch:     r.watchers.epoch,
+		}, changes)
+	}
+
+	return nil
+}
+
+// ListVersions returns a page of the versions from the oldest one, and the
+// token of the next page.
+func (r *rbac) ListVersions(ctx context.Context, pageSize int, pageToken string) ([]*Version, string, error) {
+	after := int64(0)
+	if pageToken != "" {
+		n, err := strconv.ParseInt(pageToken, 10, 64)
+		if err != nil || n < 0 {
+			return nil, "", ErrInvalidPageToken
+		}
+		after = n
+	}
+
+	limit := pageLimit(pageSize)
+	stored, err := r.versions.ListVersions(after, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrCasbin, err)
+	}
+
+	next := ""
+	if len(stored) > limit {
+		stored = stored[:limit]
+		next = strconv.FormatInt(stored[limit-1].ID, 10)
+	}
+
+	versions := make([]*Version, 0, len(stored))
+	for _, v := range stored {
+		versions = append(versions, &Version{
+			ID:        v.ID,
+			CreatedAt: v.CreatedAt,
+			Diff:      newDiff(versionChanges(v)),
+		})
+	}
+
+	return versions, next, nil
+}
+
+// GetPolicyAt returns the policies and subjects as they were at the version.
+func (r *rbac) GetPolicyAt(ctx context.Context, version int64) ([]*api.Policy, []*api.Subject, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, err := r.modelAt(version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes := make([]ruleChange, 0, len(csvSections))
+	for _, section := range csvSections {
+		changes = append(changes, ruleChange{sec: section.sec, ptype: section.ptype, added: m.GetPolicy(section.sec, section.ptype)})
+	}
+	d := newDiff(changes)
+
+	return d.AddedPolicies, d.AddedSubjects, nil
+}
+
+// DiffVersions returns the changes which turn the rules of version from into
+// the rules of version to.
+func (r *rbac) DiffVersions(ctx context.Context, from, to int64) (*Diff, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a, err := r.modelAt(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.modelAt(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDiff(diffRules(a, modelRules(b), true)), nil
+}
+
+// Rollback restores the rules of the version. The rollback is recorded as a
+// new version, so it can be rolled back too.
+func (r *rbac) Rollback(ctx context.Context, version int64) (*Diff, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target, err := r.modelAt(version)
+	if err != nil {
+		return nil, err
+	}
+	current, err := r.loadModel()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCasbin, err)
+	}
+
+	changes := diffRules(current, modelRules(target), true)
+	if _, err = r.applyChanges(changes); err != nil {
+		return nil, err
+	}
+	if err = r.commit(ctx, "Rollback", changes...); err != nil {
+		return nil, err
+	}
+
+	return newDiff(changes), nil
+}
+
+// modelAt returns the rules of the version, by reverting the versions after
+// it from the rules of the adapter.
+func (r *rbac) modelAt(version int64) (model.Model, error) {
+	if version < 0 {
+		return nil, ErrVersionNotFound
+	}
+
+	newer, err := r.versions.ListVersions(version, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCasbin, err)
+	}
+	if version > 0 {
+		found, err := r.versions.ListVersions(version-1, 1)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCasbin, err)
+		}
+		if len(found) == 0 || found[0].ID != version {
+			return nil, ErrVersionNotFound
+		}
+	}
+
+	m, err := r.loadModel()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCasbin, err)
+	}
+	for i := len(newer) - 1; i >= 0; i-- {
+		for _, c := range versionChanges(newer[i]) {
+			m.RemovePolicies(c.sec, c.ptype, c.added)
+			m.AddPolicies(c.sec, c.ptype, c.removed)
+		}
+	}
+
+	return m, nil
+}
+
+// versionChanges groups the rules of the version by ptype.
+func versionChanges(v *adapter.Version) []ruleChange {
+	changes := make([]ruleChange, 0, len(csvSections))
+	for _, section := range csvSections {
+		c := ruleChange{sec: section.sec, ptype: section.ptype}
+		for _, line := range v.Added {
+			if len(line) > 1 && line[0] == section.ptype {
+				c.added = append(c.added, line[1:])
+			}
+		}
+		for _, line := range v.Removed {
+			if len(line) > 1 && line[0] == section.ptype {
+				c.removed = append(c.removed, line[1:])
+			}
+		}
+		if len(c.added)+len(c.removed) > 0 {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// modelRules returns the rules of the model grouped by ptype.
+func modelRules(m model.Model) map[string][][]string {
+	rules := map[string][][]string{}
+	for _, section := range csvSections {
+		// the slice of the model is shifted in place by the removals
+		rules[section.ptype] = append([][]string(nil), m.GetPolicy(section.sec, section.ptype)...)
+	}
+	return rules
+}
+
+// DefaultVersionHistory is the number of versions kept in memory, when the
+// adapter doesn't implement adapter.VersionStore.
+const DefaultVersionHistory = 1000
+
+// WithVersionHistory sets the number of versions kept in memory when the
+// adapter doesn't implement adapter.VersionStore, DefaultVersionHistory by
+// default. The adapters of this package store the versions themselves.
+func WithVersionHistory(size int) Option {
+	return func(c *Config) {
+		c.versionHistory = size
+	}
+}
+
+// memoryVersions keeps the latest versions in memory, for the adapters which
+// don't implement adapter.VersionStore. The versions are lost on restart and
+// are only those made by this process, so the replicas sharing the rules
+// don't share them: ListVersions, GetPolicyAt and Rollback are meant for a
+// single process then. The older versions are dropped once size are kept,
+// they are no longer found.
+type memoryVersions struct {
+	sync.RWMutex
+	size     int
+	last     int64
+	versions []*adapter.Version
+}
+
+func newMemoryVersions(size int) *memoryVersions {
+	if size <= 0 {
+		size = DefaultVersionHistory
+	}
+	return &memoryVersions{size: size}
+}
+
+func (s *memoryVersions) AddVersion(v *adapter.Version) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.last++
+	v.ID = s.last
+	s.versions = append(s.versions, v)
+	if len(s.versions) > s.size {
+		s.versions = append(s.versions[:0:0], s.versions[len(s.versions)-s.size:]...)
+	}
+	return nil
+}
+
+func (s *memoryVersions) ListVersions(after int64, limit int) ([]*adapter.Version, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	versions := make([]*adapter.Version, 0)
+	for _, v := range s.versions {
+		if v.ID <= after {
+			continue
+		}
+		if limit > 0 && len(versions) == limit {
+			break
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
@@ -0,0 +1,260 @@
+package rbac
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vine-io/rbac/api"
+)
+
+// WatchEvent holds the events of a change of the rules, made by a mutation
+// or by another replica.
+type WatchEvent struct {
+	// Revision increases with every change, it is the resume token of Watch
+	// along with Epoch.
+	Revision int64
+	// Epoch identifies the process which numbered the revision, the
+	// revisions of another epoch can't be resumed.
+	Epoch string
+	Time  time.Time
+	// Actor, Operation and Reason describe the mutation as in ChangeEvent.
+	Actor     string
+	Operation string
+	Reason    string
+	Events    []*api.PolicyEvent
+}
+
+const (
+	// DefaultWatchHistory is the number of changes kept to resume a watch.
+	DefaultWatchHistory = 1000
+	// watchBuffer is the number of changes a watcher may lag behind before
+	// its channel is closed.
+	watchBuffer = 256
+)
+
+// WithWatchHistory sets the number of changes kept to resume a watch,
+// DefaultWatchHistory by default.
+func WithWatchHistory(size int) Option {
+	return func(c *Config) {
+		c.watchHistory = size
+	}
+}
+
+// watchHub sends the changes to the watchers, and keeps the latest ones so
+// that a watcher can resume after a revision.
+type watchHub struct {
+	mu       sync.Mutex
+	size     int
+	epoch    string
+	revision int64
+	history  []*WatchEvent
+	watchers map[chan *WatchEvent]struct{}
+	// done is closed by close
+	done chan struct{}
+}
+
+func newWatchHub(size int) *watchHub {
+	if size <= 0 {
+		size = DefaultWatchHistory
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return &watchHub{
+		size:     size,
+		epoch:    hex.EncodeToString(b),
+		watchers: map[chan *WatchEvent]struct{}{},
+		done:     make(chan struct{}),
+	}
+}
+
+// publish sends the changes made by the operation to the watchers as a new
+// revision, and returns it. A watcher which lags behind is dropped, its
+// channel is closed so that it resumes.
+func (h *watchHub) publish(ctx context.Context, operation string, changes []ruleChange) int64 {
+	events := policyEvents(changes)
+	if len(events) == 0 {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.revision++
+	ev := &WatchEvent{
+		Revision:  h.revision,
+		Epoch:     h.epoch,
+		Time:      time.Now(),
+		Actor:     Actor(ctx),
+		Operation: operation,
+		Reason:    Reason(ctx),
+		Events:    events,
+	}
+	h.history = append(h.history, ev)
+	if len(h.history) > h.size {
+		h.history = append(h.history[:0:0], h.history[len(h.history)-h.size:]...)
+	}
+
+	for ch := range h.watchers {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.watchers, ch)
+			close(ch)
+		}
+	}
+	return h.revision
+}
+
+func (h *watchHub) watch(ctx context.Context, epoch string, revision int64) (<-chan *WatchEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	select {
+	case <-h.done:
+		return nil, ErrClosed
+	default:
+	}
+
+	// the watch starts with the revision it follows
+	replay := []*WatchEvent{{Revision: h.revision, Epoch: h.epoch}}
+	if revision > 0 {
+		// the revision is compacted when the history doesn't start right
+		// after it, or unknown when it was numbered by another process
+		oldest := h.revision - int64(len(h.history))
+		if epoch != h.epoch || revision < oldest || revision > h.revision {
+			return nil, ErrRevisionCompacted
+		}
+		replay[0].Revision = revision
+		replay = append(replay, h.history[len(h.history)-int(h.revision-revision):]...)
+	}
+
+	ch := make(chan *WatchEvent, len(replay)+watchBuffer)
+	for _, ev := range replay {
+		ch <- ev
+	}
+	h.watchers[ch] = struct{}{}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-h.done:
+			return
+		}
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.watchers[ch]; ok {
+			delete(h.watchers, ch)
+			close(ch)
+		}
+	}()
+
+	return ch, nil
+}
+
+// close closes the channels of the watchers.
+func (h *watchHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	select {
+	case <-h.done:
+		return
+	default:
+		close(h.done)
+	}
+	for ch := range h.watchers {
+		delete(h.watchers, ch)
+		close(ch)
+	}
+}
+
+// Watch returns the changes of the rules made after the revision of the
+// epoch, or from now on when revision is 0. The first event has no events
+// and holds the revision which the watch follows.
+//
+// The channel is closed when ctx is done, when RBAC is closed, or when the
+// receiver lags too far behind; the receiver then calls Watch again with the
+// last epoch and revision it got. ErrRevisionCompacted means the changes
+// after the revision are no longer kept, or were numbered by another process,
+// and the rules must be read again. ErrClosed means RBAC is closed.
+func (r *rbac) Watch(ctx context.Context, epoch string, revision int64) (<-chan *WatchEvent, error) {
+	return r.watchers.watch(ctx, epoch, revision)
+}
+
+// policyEvents describes the changes. A removed rule and an added rule of
+// the same ptype which differ by one field are reported as an update.
+func policyEvents(changes []ruleChange) []*api.PolicyEvent {
+	events := make([]*api.PolicyEvent, 0)
+	for _, c := range changes {
+		ptype := api.ParsePtype(c.ptype)
+		event := func(t api.EventType, rule, previous []string) *api.PolicyEvent {
+			ev := &api.PolicyEvent{Type: t}
+			if c.sec == "p" {
+				ev.Policy = newPolicy(rule[0], rule[1], rule[2:]...)
+				if previous != nil {
+					ev.PreviousPolicy = newPolicy(previous[0], previous[1], previous[2:]...)
+				}
+			} else {
+				ev.Subject = &api.Subject{Ptype: ptype, User: rule[0], Group: rule[1]}
+				if previous != nil {
+					ev.PreviousSubject = &api.Subject{Ptype: ptype, User: previous[0], Group: previous[1]}
+				}
+			}
+			return ev
+		}
+
+		// removed indexes the removed rules by their fields but one
+		removed := map[string][]int{}
+		for i, rule := range c.removed {
+			for _, key := range updateKeys(rule) {
+				removed[key] = append(removed[key], i)
+			}
+		}
+		replaced := make([]bool, len(c.removed))
+
+		for _, rule := range c.added {
+			previous := -1
+			for _, key := range updateKeys(rule) {
+				for _, i := range removed[key] {
+					if !replaced[i] {
+						previous = i
+						break
+					}
+				}
+				if previous >= 0 {
+					break
+				}
+			}
+
+			if previous < 0 {
+				events = append(events, event(api.EventType_ADDED, rule, nil))
+				continue
+			}
+			replaced[previous] = true
+			events = append(events, event(api.EventType_UPDATED, rule, c.removed[previous]))
+		}
+		for i, rule := range c.removed {
+			if !replaced[i] {
+				events = append(events, event(api.EventType_REMOVED, rule, nil))
+			}
+		}
+	}
+
+	return events
+}
+
+// updateKeys returns the keys of the rule with each field left out in turn.
+func updateKeys(rule []string) []string {
+	keys := make([]string, 0, len(rule))
+	for i := range rule {
+		fields := append(append([]string{}, rule[:i]...), "")
+		fields = append(fields, rule[i+1:]...)
+		keys = append(keys, strconv.Itoa(i)+"\x00"+strings.Join(fields, "\x00"))
+	}
+	return keys
+}
@@ -0,0 +1,27 @@
+package wrapper
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// NewGinMiddleware returns a gin middleware which enforces the policies on
+// every request, except the public routes, for the subject read by extractor
+// from the headers. The route template is the one matched by gin, such as
+// "/users/:id", or the path when none matches.
+func NewGinMiddleware(enforcer Enforcer, extractor SubjectExtractor, opts ...Option) gin.HandlerFunc {
+	options := newOptions(extractor, opts...)
+
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+
+		if code, err := enforceHTTP(enforcer, options, c.Request, route); err != nil {
+			options.Deny(c.Writer, c.Request, code, err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,96 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/vine-io/rbac/api"
+	vapi "github.com/vine-io/vine/lib/api"
+	"github.com/vine-io/vine/util/context/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	gmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MethodEndpointFunc returns the endpoint of a gRPC request from the full
+// name of its method, such as "/helloworld.Greeter/SayHello".
+type MethodEndpointFunc func(fullMethod string) *vapi.Endpoint
+
+// MethodEndpoint is the default MethodEndpointFunc, the object of the policy
+// is the service and the action is the method, such as "helloworld.Greeter"
+// and "SayHello".
+func MethodEndpoint(fullMethod string) *vapi.Endpoint {
+	name := strings.TrimPrefix(fullMethod, "/")
+	service, method := name, ""
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		service, method = name[:i], name[i+1:]
+	}
+
+	return &vapi.Endpoint{
+		Name:   fullMethod,
+		Entity: service,
+		Method: []string{method},
+	}
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor which enforces
+// the policies on every request. The subject is read by extractor from the
+// incoming metadata. A request without a subject fails with
+// codes.Unauthenticated, and a denied one with codes.PermissionDenied.
+func UnaryServerInterceptor(enforcer Enforcer, extractor SubjectExtractor, opts ...Option) grpc.UnaryServerInterceptor {
+	options := newOptions(extractor, opts...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := enforceMethod(ctx, enforcer, options, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor which
+// enforces the policies when a stream is opened, like UnaryServerInterceptor.
+func StreamServerInterceptor(enforcer Enforcer, extractor SubjectExtractor, opts ...Option) grpc.StreamServerInterceptor {
+	options := newOptions(extractor, opts...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := enforceMethod(ss.Context(), enforcer, options, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func enforceMethod(ctx context.Context, enforcer Enforcer, options Options, fullMethod string) error {
+	// the extractors read the vine metadata
+	if md, ok := gmetadata.FromIncomingContext(ctx); ok {
+		vmd := metadata.Metadata{}
+		for key, values := range md {
+			if len(values) > 0 {
+				vmd[key] = values[0]
+			}
+		}
+		ctx = metadata.MergeContext(ctx, vmd, false)
+	}
+
+	sub, err := options.Extractor.Extract(ctx)
+	if err != nil {
+		if errors.Is(err, ErrNoSubject) || errors.Is(err, ErrInvalidToken) {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	endpoint := options.MethodEndpoint(fullMethod)
+	ok, err := enforcer.Enforce(ctx, &api.Policy{Sub: sub, Endpoint: endpoint})
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s", sub, fullMethod)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package wrapper
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/api"
+	vapi "github.com/vine-io/vine/lib/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	gmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestMethodEndpoint(t *testing.T) {
+	endpoint := MethodEndpoint("/helloworld.Greeter/SayHello")
+	assert.Equal(t, "helloworld.Greeter", endpoint.Entity)
+	assert.Equal(t, []string{"SayHello"}, endpoint.Method)
+}
+
+func TestServerInterceptors(t *testing.T) {
+	r := newTestRBAC(t)
+	assert.NoError(t, r.AddPolicy(context.TODO(), api.NewPolicyWithString("lack", "helloworld.Greeter", "SayHello")))
+
+	incoming := func(sub string) context.Context {
+		return gmetadata.NewIncomingContext(context.TODO(), gmetadata.Pairs(rbac.ActorKey, sub))
+	}
+
+	for name, enforcer := range map[string]Enforcer{"local": r, "remote": Remote(&testClient{r: r})} {
+		t.Run(name, func(t *testing.T) {
+			unary := UnaryServerInterceptor(enforcer, MetadataExtractor(""))
+			info := &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/SayHello"}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "hello", nil }
+
+			rsp, err := unary(incoming("lack"), nil, info, handler)
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", rsp)
+			_, err = unary(incoming("bob"), nil, info, handler)
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			_, err = unary(context.TODO(), nil, info, handler)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+			stream := StreamServerInterceptor(enforcer, MetadataExtractor(""))
+			sinfo := &grpc.StreamServerInfo{FullMethod: "/helloworld.Greeter/SayHello"}
+			called := false
+			shandler := func(srv interface{}, ss grpc.ServerStream) error {
+				called = true
+				return nil
+			}
+
+			assert.NoError(t, stream(nil, &testStream{ctx: incoming("lack")}, sinfo, shandler))
+			assert.True(t, called)
+			called = false
+			err = stream(nil, &testStream{ctx: incoming("bob")}, sinfo, shandler)
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			assert.False(t, called)
+		})
+	}
+
+	// the mapping can be changed, such as to a single object per service
+	unary := UnaryServerInterceptor(r, MetadataExtractor(""), WithMethodEndpoint(func(fullMethod string) *vapi.Endpoint {
+		return &vapi.Endpoint{Entity: "helloworld.Greeter", Method: []string{"SayHello"}}
+	}))
+	_, err := unary(incoming("lack"), nil, &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/SayGoodbye"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+	assert.NoError(t, err)
+}
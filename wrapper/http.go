@@ -0,0 +1,140 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/vine-io/rbac/api"
+	vapi "github.com/vine-io/vine/lib/api"
+	"github.com/vine-io/vine/util/context/metadata"
+)
+
+// RouteFunc returns the route template of a request, such as "/users/{id}".
+type RouteFunc func(r *http.Request) string
+
+// RequestPath is the default RouteFunc, it returns the path of the request
+// since net/http doesn't expose the matched pattern. The policies then name
+// every path, such as "/users/42": a policy of "/users/{id}" matches no
+// request. Routers with path parameters need WithRoute.
+func RequestPath(r *http.Request) string {
+	return r.URL.Path
+}
+
+// DenyFunc writes the response of a request which is not allowed, code is
+// http.StatusUnauthorized, http.StatusForbidden or
+// http.StatusInternalServerError.
+type DenyFunc func(w http.ResponseWriter, r *http.Request, code int, err error)
+
+// DefaultDeny writes the error as plain text.
+func DefaultDeny(w http.ResponseWriter, r *http.Request, code int, err error) {
+	http.Error(w, err.Error(), code)
+}
+
+// WithRoute sets how the route template of a net/http request is read, by
+// default with RequestPath, which is the raw path. Set it when the routes
+// have path parameters, from the router which matched the request.
+func WithRoute(fn RouteFunc) Option {
+	return func(o *Options) {
+		o.Route = fn
+	}
+}
+
+// WithPublicRoutes lets the requests of the routes through without a
+// subject, such as "GET /healthz", or "/login" for every method.
+func WithPublicRoutes(routes ...string) Option {
+	return func(o *Options) {
+		for _, route := range routes {
+			o.Public[route] = struct{}{}
+		}
+	}
+}
+
+// WithDeny sets how the responses of the requests which are not allowed are
+// written, by default with DefaultDeny.
+func WithDeny(fn DenyFunc) Option {
+	return func(o *Options) {
+		o.Deny = fn
+	}
+}
+
+// HTTPEndpoint returns the endpoint of a HTTP request, the object of the
+// policy is the route template and the action is the HTTP method, such as
+// "/users/{id}" and "GET".
+func HTTPEndpoint(route, method string) *vapi.Endpoint {
+	return &vapi.Endpoint{
+		Name:   method + " " + route,
+		Entity: route,
+		Method: []string{method},
+		Path:   []string{route},
+	}
+}
+
+// NewHTTPMiddleware returns a net/http middleware which enforces the policies
+// on every request, except the public routes, for the subject read by
+// extractor from the headers.
+//
+// The object of the policies is the route of the request, which is the raw
+// path unless WithRoute reads the template from the router: without it, a
+// policy of "/users/{id}" never matches, and each path such as "/users/42"
+// must be granted on its own.
+func NewHTTPMiddleware(enforcer Enforcer, extractor SubjectExtractor, opts ...Option) func(http.Handler) http.Handler {
+	options := newOptions(extractor, opts...)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if code, err := enforceHTTP(enforcer, options, r, options.Route(r)); err != nil {
+				options.Deny(w, r, code, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// enforceHTTP returns the status code and the error of the response when the
+// request isn't allowed.
+func enforceHTTP(enforcer Enforcer, options Options, r *http.Request, route string) (int, error) {
+	if options.isPublic(r.Method, route) {
+		return 0, nil
+	}
+
+	sub, err := options.Extractor.Extract(headerContext(r))
+	if err != nil {
+		if errors.Is(err, ErrNoSubject) || errors.Is(err, ErrInvalidToken) {
+			return http.StatusUnauthorized, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	ok, err := enforcer.Enforce(r.Context(), &api.Policy{Sub: sub, Endpoint: HTTPEndpoint(route, r.Method)})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusForbidden, errors.New(sub + " is not allowed to " + r.Method + " " + route)
+	}
+
+	return 0, nil
+}
+
+func (o *Options) isPublic(method, route string) bool {
+	if _, ok := o.Public[route]; ok {
+		return true
+	}
+	_, ok := o.Public[method+" "+route]
+	return ok
+}
+
+// headerContext returns the context of the request carrying its headers as
+// vine metadata, which the extractors read.
+func headerContext(r *http.Request) context.Context {
+	md := metadata.Metadata{}
+	for key, values := range r.Header {
+		if len(values) > 0 {
+			md[strings.ToLower(key)] = values[0]
+		}
+	}
+	return metadata.MergeContext(r.Context(), md, false)
+}
@@ -0,0 +1,81 @@
+package wrapper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/api"
+)
+
+func newHTTPRequest(method, target, sub string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	if sub != "" {
+		req.Header.Set(rbac.ActorKey, sub)
+	}
+	return req
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	r := newTestRBAC(t)
+	assert.NoError(t, r.AddPolicy(context.TODO(), api.NewPolicyWithString("lack", "/users", "GET")))
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := NewHTTPMiddleware(r, MetadataExtractor(""), WithPublicRoutes("GET /healthz"))(ok)
+
+	for _, c := range []struct {
+		method, target, sub string
+		code                int
+	}{
+		{http.MethodGet, "/users", "lack", http.StatusOK},
+		{http.MethodPost, "/users", "lack", http.StatusForbidden},
+		{http.MethodGet, "/users", "", http.StatusUnauthorized},
+		{http.MethodGet, "/healthz", "", http.StatusOK},
+		{http.MethodPost, "/healthz", "", http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newHTTPRequest(c.method, c.target, c.sub))
+		assert.Equal(t, c.code, w.Code, "%s %s %s", c.method, c.target, c.sub)
+	}
+
+	// the responses can be customized
+	h = NewHTTPMiddleware(r, MetadataExtractor(""), WithDeny(func(w http.ResponseWriter, r *http.Request, code int, err error) {
+		w.WriteHeader(http.StatusNotFound)
+	}))(ok)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newHTTPRequest(http.MethodDelete, "/users", "lack"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := newTestRBAC(t)
+	assert.NoError(t, r.AddPolicy(context.TODO(), api.NewPolicyWithString("lack", "/users/:id", "GET")))
+
+	engine := gin.New()
+	engine.Use(NewGinMiddleware(r, MetadataExtractor(""), WithPublicRoutes("/login")))
+	handler := func(c *gin.Context) { c.Status(http.StatusOK) }
+	engine.GET("/users/:id", handler)
+	engine.DELETE("/users/:id", handler)
+	engine.POST("/login", handler)
+
+	for _, c := range []struct {
+		method, target, sub string
+		code                int
+	}{
+		{http.MethodGet, "/users/1", "lack", http.StatusOK},
+		{http.MethodGet, "/users/2", "bob", http.StatusForbidden},
+		{http.MethodDelete, "/users/1", "lack", http.StatusForbidden},
+		{http.MethodGet, "/users/1", "", http.StatusUnauthorized},
+		{http.MethodPost, "/login", "", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, newHTTPRequest(c.method, c.target, c.sub))
+		assert.Equal(t, c.code, w.Code, "%s %s %s", c.method, c.target, c.sub)
+	}
+}
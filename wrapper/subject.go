@@ -0,0 +1,160 @@
+package wrapper
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/vine/util/context/metadata"
+)
+
+var (
+	// ErrNoSubject means the request doesn't carry a subject.
+	ErrNoSubject = errors.New("missing subject")
+	// ErrInvalidToken means the token carrying the subject can't be trusted.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrMissingKey means JWTExtractor has no key to verify the tokens.
+	ErrMissingKey = errors.New("missing token key")
+)
+
+// SubjectExtractor returns the subject making a request, from the vine
+// metadata of its context.
+type SubjectExtractor interface {
+	Extract(ctx context.Context) (string, error)
+}
+
+// SubjectExtractorFunc is a function which implements SubjectExtractor.
+type SubjectExtractorFunc func(ctx context.Context) (string, error)
+
+func (f SubjectExtractorFunc) Extract(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// MetadataExtractor reads the subject from the metadata key, rbac.ActorKey
+// when it is empty.
+//
+// Warning: the metadata is supplied by the caller, which can claim to be any
+// subject, such as the admin. MetadataExtractor is only safe behind a trusted
+// gateway which authenticates the callers and overwrites the key. Use
+// JWTExtractor otherwise.
+func MetadataExtractor(key string) SubjectExtractor {
+	if key == "" {
+		key = rbac.ActorKey
+	}
+
+	return SubjectExtractorFunc(func(ctx context.Context) (string, error) {
+		sub, ok := metadata.Get(ctx, key)
+		if !ok || sub == "" {
+			return "", ErrNoSubject
+		}
+		return sub, nil
+	})
+}
+
+// JWTExtractor reads the subject from the claim of the bearer token of the
+// Authorization metadata, "sub" when claim is empty. The token must be
+// signed with key by HS256, HS384 or HS512, and must carry an "exp" claim
+// which isn't past. It fails with ErrMissingKey when key is empty, since
+// anyone could sign the tokens.
+func JWTExtractor(key []byte, claim string) (SubjectExtractor, error) {
+	if len(key) == 0 {
+		return nil, ErrMissingKey
+	}
+	if claim == "" {
+		claim = "sub"
+	}
+
+	return SubjectExtractorFunc(func(ctx context.Context) (string, error) {
+		auth, ok := metadata.Get(ctx, "Authorization")
+		if !ok || !strings.HasPrefix(auth, "Bearer ") {
+			return "", ErrNoSubject
+		}
+
+		claims, err := parseJWT(strings.TrimPrefix(auth, "Bearer "), key)
+		if err != nil {
+			return "", err
+		}
+		sub, _ := claims[claim].(string)
+		if sub == "" {
+			return "", ErrNoSubject
+		}
+		return sub, nil
+	}), nil
+}
+
+// parseJWT verifies the signature and the validity period of the token, and
+// returns its claims.
+func parseJWT(token string, key []byte) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	var h func() hash.Hash
+	switch header.Alg {
+	case "HS256":
+		h = sha256.New
+	case "HS384":
+		h = sha512.New384
+	case "HS512":
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	mac := hmac.New(h, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	claims := map[string]interface{}{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := float64(time.Now().Unix())
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now >= exp {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
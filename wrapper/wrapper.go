@@ -0,0 +1,141 @@
+// Package wrapper enforces the policies of rbac on the requests of vine,
+// gRPC and HTTP services.
+package wrapper
+
+import (
+	"context"
+	"errors"
+
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/vine/core/server"
+	vapi "github.com/vine-io/vine/lib/api"
+	verrs "github.com/vine-io/vine/lib/errors"
+)
+
+// Enforcer decides whether a request is allowed. It is implemented by
+// rbac.RBAC, and by Remote for the services which use the rbac service.
+type Enforcer interface {
+	Enforce(ctx context.Context, p *api.Policy) (bool, error)
+}
+
+type remote struct {
+	client api.RBACService
+}
+
+// Remote returns an Enforcer calling the Enforce RPC of the rbac service.
+func Remote(client api.RBACService) Enforcer {
+	return &remote{client: client}
+}
+
+func (r *remote) Enforce(ctx context.Context, p *api.Policy) (bool, error) {
+	rsp, err := r.client.Enforce(ctx, &api.EnforceRequest{Policy: p})
+	if err != nil {
+		return false, err
+	}
+	return rsp.Result, nil
+}
+
+// EndpointFunc returns the endpoint of a request.
+type EndpointFunc func(req server.Request) *vapi.Endpoint
+
+// RequestEndpoint is the default EndpointFunc, the object of the policy is
+// the service and the action is the method, such as "Greeter.Hello".
+func RequestEndpoint(req server.Request) *vapi.Endpoint {
+	return &vapi.Endpoint{
+		Name:   req.Endpoint(),
+		Entity: req.Service(),
+		Method: []string{req.Method()},
+	}
+}
+
+// Options configures the HandlerWrapper, the gRPC interceptors and the HTTP
+// middlewares.
+type Options struct {
+	// Extractor reads the subject of a request, it is the argument of the
+	// wrappers.
+	Extractor SubjectExtractor
+	Endpoint  EndpointFunc
+	// MethodEndpoint builds the endpoint of the gRPC requests.
+	MethodEndpoint MethodEndpointFunc
+	// Route returns the route template of the net/http requests.
+	Route RouteFunc
+	// Public holds the routes which are not enforced, as "METHOD route" or
+	// "route" for every method.
+	Public map[string]struct{}
+	// Deny writes the response of the HTTP requests which are not allowed.
+	Deny DenyFunc
+}
+
+// Option sets an optional field of Options.
+type Option func(*Options)
+
+// WithEndpoint sets how the endpoint of a request is built, by default with
+// RequestEndpoint.
+func WithEndpoint(fn EndpointFunc) Option {
+	return func(o *Options) {
+		o.Endpoint = fn
+	}
+}
+
+// WithMethodEndpoint sets how the endpoint of a gRPC request is built, by
+// default with MethodEndpoint.
+func WithMethodEndpoint(fn MethodEndpointFunc) Option {
+	return func(o *Options) {
+		o.MethodEndpoint = fn
+	}
+}
+
+// newOptions returns the options of a wrapper which reads the subject with
+// extractor. A nil extractor finds no subject, so every request is rejected.
+func newOptions(extractor SubjectExtractor, opts ...Option) Options {
+	if extractor == nil {
+		extractor = SubjectExtractorFunc(func(ctx context.Context) (string, error) {
+			return "", ErrNoSubject
+		})
+	}
+
+	options := Options{
+		Extractor:      extractor,
+		Endpoint:       RequestEndpoint,
+		MethodEndpoint: MethodEndpoint,
+		Route:          RequestPath,
+		Public:         map[string]struct{}{},
+		Deny:           DefaultDeny,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// NewHandlerWrapper returns a server.HandlerWrapper which enforces the
+// policies on every request, for the subject read by extractor. The subject
+// decides what a request may do, so it must come from a source the service
+// trusts, such as JWTExtractor. A request without a subject fails with
+// verrs.Unauthorized, and a denied one with verrs.Forbidden.
+func NewHandlerWrapper(enforcer Enforcer, extractor SubjectExtractor, opts ...Option) server.HandlerWrapper {
+	options := newOptions(extractor, opts...)
+
+	return func(h server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			sub, err := options.Extractor.Extract(ctx)
+			if err != nil {
+				if errors.Is(err, ErrNoSubject) || errors.Is(err, ErrInvalidToken) {
+					return verrs.Unauthorized(req.Service(), err.Error())
+				}
+				return verrs.InternalServerError(req.Service(), err.Error())
+			}
+
+			endpoint := options.Endpoint(req)
+			ok, err := enforcer.Enforce(ctx, &api.Policy{Sub: sub, Endpoint: endpoint})
+			if err != nil {
+				return verrs.InternalServerError(req.Service(), err.Error())
+			}
+			if !ok {
+				return verrs.Forbidden(req.Service(), "%s is not allowed to call %s", sub, endpoint.Name)
+			}
+
+			return h(ctx, req, rsp)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package wrapper
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vine-io/rbac"
+	"github.com/vine-io/rbac/adapter"
+	"github.com/vine-io/rbac/api"
+	"github.com/vine-io/vine/core/client"
+	"github.com/vine-io/vine/core/codec"
+	"github.com/vine-io/vine/core/server"
+	verrs "github.com/vine-io/vine/lib/errors"
+	"github.com/vine-io/vine/util/context/metadata"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type testRequest struct {
+	service, method string
+}
+
+func (r *testRequest) Service() string           { return r.service }
+func (r *testRequest) Method() string            { return r.method }
+func (r *testRequest) Endpoint() string          { return r.method }
+func (r *testRequest) ContentType() string       { return "application/json" }
+func (r *testRequest) Header() map[string]string { return map[string]string{} }
+func (r *testRequest) Body() interface{}         { return nil }
+func (r *testRequest) Read() ([]byte, error)     { return nil, nil }
+func (r *testRequest) Codec() codec.Reader       { return nil }
+func (r *testRequest) Stream() bool              { return false }
+
+var _ server.Request = (*testRequest)(nil)
+
+type testClient struct {
+	api.RBACService
+	r rbac.RBAC
+}
+
+func (c *testClient) Enforce(ctx context.Context, in *api.EnforceRequest, opts ...client.CallOption) (*api.EnforceResponse, error) {
+	ok, err := c.r.Enforce(ctx, in.Policy)
+	if err != nil {
+		return nil, err
+	}
+	return &api.EnforceResponse{Result: ok}, nil
+}
+
+func newTestRBAC(t *testing.T) rbac.RBAC {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "wrapper.sqlite.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apt, err := adapter.NewGormAdapter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := rbac.NewConfig(apt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rbac.NewRBAC(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, r.AddPolicy(context.TODO(), api.NewPolicyWithString("lack", "go.vine.helloworld", "Helloworld.Call")))
+	return r
+}
+
+func code(err error) verrs.StatusCode {
+	if err == nil {
+		return 0
+	}
+	return verrs.Parse(err.Error()).Code
+}
+
+func TestHandlerWrapper(t *testing.T) {
+	r := newTestRBAC(t)
+
+	for name, enforcer := range map[string]Enforcer{"local": r, "remote": Remote(&testClient{r: r})} {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			h := NewHandlerWrapper(enforcer, MetadataExtractor(""))(func(ctx context.Context, req server.Request, rsp interface{}) error {
+				called = true
+				return nil
+			})
+
+			req := &testRequest{service: "go.vine.helloworld", method: "Helloworld.Call"}
+			assert.NoError(t, h(metadata.Set(context.TODO(), rbac.ActorKey, "lack"), req, nil))
+			assert.True(t, called)
+
+			called = false
+			assert.Equal(t, verrs.StatusForbidden, code(h(metadata.Set(context.TODO(), rbac.ActorKey, "bob"), req, nil)))
+			assert.Equal(t, verrs.StatusUnauthorized, code(h(context.TODO(), req, nil)))
+			assert.False(t, called)
+		})
+	}
+}
+
+func TestMissingExtractor(t *testing.T) {
+	r := newTestRBAC(t)
+
+	// without an extractor, no subject is trusted
+	h := NewHandlerWrapper(r, nil)(func(ctx context.Context, req server.Request, rsp interface{}) error {
+		return nil
+	})
+	req := &testRequest{service: "go.vine.helloworld", method: "Helloworld.Call"}
+	assert.Equal(t, verrs.StatusUnauthorized, code(h(metadata.Set(context.TODO(), rbac.ActorKey, "lack"), req, nil)))
+}
+
+func newJWT(key []byte, claims string) string {
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTExtractor(t *testing.T) {
+	_, err := JWTExtractor(nil, "")
+	assert.ErrorIs(t, err, ErrMissingKey)
+
+	key := []byte("secret")
+	extractor, err := JWTExtractor(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := func(token string) context.Context {
+		return metadata.Set(context.TODO(), "Authorization", "Bearer "+token)
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+
+	sub, err := extractor.Extract(bearer(newJWT(key, fmt.Sprintf(`{"sub":"lack","exp":%d}`, exp))))
+	assert.NoError(t, err)
+	assert.Equal(t, "lack", sub)
+
+	_, err = extractor.Extract(bearer(newJWT([]byte("other"), fmt.Sprintf(`{"sub":"lack","exp":%d}`, exp))))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// a token must expire
+	_, err = extractor.Extract(bearer(newJWT(key, `{"sub":"lack"}`)))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	expired := time.Now().Add(-time.Minute).Unix()
+	_, err = extractor.Extract(bearer(newJWT(key, fmt.Sprintf(`{"sub":"lack","exp":%d}`, expired))))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = extractor.Extract(context.TODO())
+	assert.ErrorIs(t, err, ErrNoSubject)
+
+	extractor, err = JWTExtractor(key, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err = extractor.Extract(bearer(newJWT(key, fmt.Sprintf(`{"name":"bob","exp":%d}`, exp))))
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", sub)
+}